@@ -25,7 +25,7 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	shutdown := bootstrap.Run(fileConf, envConf)
+	shutdown := bootstrap.Run(*configPath, fileConf, envConf)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
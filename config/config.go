@@ -31,10 +31,20 @@ type MetricsConfig struct {
 	Hosts []string `yaml:"hosts"`
 }
 
+// Админский API лимитеров включен, если задан хотя бы один адрес
+type AdminConfig struct {
+	Hosts []string `yaml:"hosts"`
+}
+
 type FileConfig struct {
 	Proxy       ReverseProxyConfig `yaml:"proxy"`
 	EdgeLimiter EdgeLimiterConfig  `yaml:"edge_limiter"`
 	Metrics     MetricsConfig      `yaml:"metrics"`
+	Admin       AdminConfig        `yaml:"admin"`
+	// сети прокси, которым доверяются X-Forwarded-For и Forwarded
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// наборы правил для клиентов по именам, перечитываются без перезапуска
+	Clients map[string][]ClientTierSettings `yaml:"clients"`
 }
 
 type ServerConfig struct {
@@ -14,6 +14,10 @@ const (
 	SlidingWindowCounterAlgorithm AlgorithmType = "sliding_window_counter"
 	SlidingWindowLogAlgorithm     AlgorithmType = "sliding_window_log"
 	TokenBucketAlgorithm          AlgorithmType = "token_bucket"
+	GCRAAlgorithm                 AlgorithmType = "gcra"
+	LeakyBucketAlgorithm          AlgorithmType = "leaky_bucket"
+	ConcurrencyAlgorithm          AlgorithmType = "concurrency"
+	AdaptiveAlgorithm             AlgorithmType = "adaptive"
 )
 
 type TokenBucketSettings struct {
@@ -37,19 +41,205 @@ type SlidingWindowCounterSettings struct {
 	BucketsNum     int           `yaml:"buckets_number"`
 }
 
+type GCRASettings struct {
+	Limit  int           `yaml:"limit"`
+	Period time.Duration `yaml:"period"`
+	Burst  int           `yaml:"burst"`
+}
+
+type LeakyBucketSettings struct {
+	Rate     float64       `yaml:"rate"`
+	MaxDelay time.Duration `yaml:"max_delay"`
+}
+
+type ConcurrencySettings struct {
+	Limit    int           `yaml:"limit"`
+	LeaseTTL time.Duration `yaml:"lease_ttl"`
+}
+
+type AdaptiveController string
+
+const (
+	AIMDController     AdaptiveController = "aimd"
+	GradientController AdaptiveController = "gradient"
+)
+
+type AdaptiveSettings struct {
+	Controller   AdaptiveController `yaml:"controller"`
+	InitialLimit int                `yaml:"initial_limit"`
+	MinLimit     int                `yaml:"min_limit"`
+	MaxLimit     int                `yaml:"max_limit"`
+	Backoff      float64            `yaml:"backoff"`
+
+	// только для aimd
+	LatencyThreshold time.Duration `yaml:"latency_threshold"`
+	// только для gradient
+	Smoothing float64 `yaml:"smoothing"`
+}
+
+type StorageBackend string
+
+const (
+	RedisStorageBackend  StorageBackend = "redis"
+	MemoryStorageBackend StorageBackend = "memory"
+)
+
+type StorageMode string
+
+const (
+	// Обновления сериализуются блокировкой внутри одного экземпляра шлюза
+	LockStorageMode StorageMode = "lock"
+	// Обновления выполняются транзакцией WATCH/MULTI с повтором при конфликте,
+	// безопасно при нескольких экземплярах шлюза
+	OptimisticStorageMode StorageMode = "optimistic"
+)
+
+// Приближенный режим: экземпляр шлюза арендует пачки квоты и расходует их локально
+type HybridSettings struct {
+	// сколько единиц арендуется за раз, ошибка экземпляра - не больше 2*batch
+	Batch int `yaml:"batch"`
+	// время жизни аренды
+	SyncInterval time.Duration `yaml:"sync_interval"`
+}
+
+type LimiterMode string
+
+const (
+	EnforceLimiterMode LimiterMode = "enforce"
+	// решения только записываются в метрики и лог, запросы не отклоняются
+	ShadowLimiterMode LimiterMode = "shadow"
+)
+
+// Что делать с запросом, если хранилище лимитера недоступно
+type FailPolicy string
+
+const (
+	// запрос пропускается
+	OpenFailPolicy FailPolicy = "open"
+	// запрос отклоняется с 503
+	ClosedFailPolicy FailPolicy = "closed"
+	// решение принимается по состоянию в памяти процесса, пока хранилище не восстановится
+	LocalFailPolicy FailPolicy = "local"
+)
+
 type StorageSettings struct {
-	KeyTTL time.Duration `yaml:"ttl"`
+	Backend StorageBackend `yaml:"backend"`
+	KeyTTL  time.Duration  `yaml:"ttl"`
+
+	// только для redis
+	Mode       StorageMode `yaml:"mode"`
+	MaxRetries int         `yaml:"max_retries"`
+	// после breaker_failures ошибок подряд хранилище не опрашивается breaker_cooldown
+	BreakerFailures int           `yaml:"breaker_failures"`
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown"`
+
+	// для memory и резервного хранилища on_error: local
+	MaxMemory       int64         `yaml:"max_memory"`
+	CleanupInterval time.Duration `yaml:"cleanup_interval"`
 }
 
 type LimiterSettings struct {
-	Storage   *StorageSettings `yaml:"storages,omitempty"`
-	Type      AlgorithmType    `yaml:"type"`
-	Algorithm any              `yaml:"algorithm"`
+	Storage *StorageSettings `yaml:"storage,omitempty"`
+	// источник ключа, например "header:X-Api-Key|ip + route"
+	Key string `yaml:"key,omitempty"`
+	// поведение при недоступном хранилище
+	OnError   FailPolicy    `yaml:"on_error,omitempty"`
+	Mode      LimiterMode   `yaml:"mode,omitempty"`
+	Type      AlgorithmType `yaml:"type"`
+	Algorithm any           `yaml:"algorithm"`
+
+	// Многоуровневая квота: запрос проходит, только если его пропускают все уровни.
+	// Хранилище и ключ общие и задаются у лимитера, у уровней - только алгоритм
+	Tiers []LimiterSettings `yaml:"tiers,omitempty"`
+	// имя уровня в метриках
+	Name string `yaml:"name,omitempty"`
+
+	// стоимость запросов, по умолчанию каждый запрос стоит 1
+	Costs []CostSettings `yaml:"costs,omitempty"`
+
+	// nil - каждое решение принимается по общему хранилищу
+	Hybrid *HybridSettings `yaml:"hybrid,omitempty"`
+
+	// теневые лимитеры, работающие рядом с этим. Без своих storage, key
+	// и costs используют настройки этого лимитера
+	Shadow []LimiterSettings `yaml:"shadow,omitempty"`
+
+	// имя набора правил из секции clients, проверяются до алгоритма
+	Clients string `yaml:"clients,omitempty"`
+
+	// nil - квота подсети клиента не проверяется
+	Subnet *SubnetSettings `yaml:"subnet,omitempty"`
+
+	// квоту расходуют только ответы с этими статусами: коды ("401") и классы ("5xx")
+	CountOnStatus []string `yaml:"count_on_status,omitempty"`
+	// квота возвращается, если сервис недоступен или ответил этими статусами.
+	// Только для лимитеров proxy
+	RefundOnStatus []string `yaml:"refund_on_status,omitempty"`
+
+	// nil - ключи не блокируются
+	Ban *BanSettings `yaml:"ban,omitempty"`
+	// nil - каждый запрос обращается к хранилищу
+	DenyCache *DenyCacheSettings `yaml:"deny_cache,omitempty"`
+}
+
+// Локальный кэш отказов, size - сколько ключей хранится
+type DenyCacheSettings struct {
+	Size int `yaml:"size"`
+}
+
+// Ключ блокируется на duration, если получил strikes отказов за window
+type BanSettings struct {
+	Strikes  int           `yaml:"strikes"`
+	Window   time.Duration `yaml:"window"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Квота подсети клиента, проверяется после квоты ключа лимитера
+type SubnetSettings struct {
+	// длины префиксов подсети, по умолчанию /24 и /64
+	IPv4 int `yaml:"ipv4"`
+	IPv6 int `yaml:"ipv6"`
+	// алгоритм квоты подсети, хранилище - как у лимитера
+	Limiter LimiterSettings `yaml:"limiter"`
+}
+
+type ClientAction string
+
+const (
+	LimitClientAction ClientAction = "limit"
+	AllowClientAction ClientAction = "allow"
+	DenyClientAction  ClientAction = "deny"
+)
+
+// Правило для группы клиентов: по ключу или по сети клиента
+type ClientTierSettings struct {
+	Name string `yaml:"name"`
+	// шаблоны path.Match для ключа лимитера
+	Keys []string `yaml:"keys"`
+	// сети или отдельные адреса
+	CIDRs []string `yaml:"cidrs"`
+	// по умолчанию limit
+	Action ClientAction `yaml:"action"`
+	// для limit - алгоритм вместо алгоритма лимитера, хранилище по умолчанию - как у лимитера
+	Limiter *LimiterSettings `yaml:"limiter,omitempty"`
+}
+
+type CostSettings struct {
+	Method string `yaml:"method"`
+	// шаблон path.Match
+	Path string `yaml:"path"`
+	Cost int    `yaml:"cost"`
+	// единица стоимости за каждые body_bytes тела запроса
+	BodyBytes int64 `yaml:"body_bytes"`
 }
 
 func (l *LimiterSettings) UnmarshalYAML(node *yaml.Node) error {
+	// список - сокращенная запись многоуровневой квоты
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&l.Tiers)
+	}
 	if node.Kind != yaml.MappingNode {
-		return fmt.Errorf("limiter must be a mapping")
+		return fmt.Errorf("limiter must be a mapping or a list of tiers")
 	}
 
 	var n yaml.Node
@@ -57,14 +247,49 @@ func (l *LimiterSettings) UnmarshalYAML(node *yaml.Node) error {
 		return err
 	}
 	var raw struct {
-		Storage   *StorageSettings `yaml:"storages,omitempty"`
-		Type      AlgorithmType    `yaml:"type"`
-		Algorithm yaml.Node        `yaml:"algorithm"`
+		Storage *StorageSettings `yaml:"storage,omitempty"`
+		// устаревшее название storage
+		Storages  *StorageSettings  `yaml:"storages,omitempty"`
+		Key       string            `yaml:"key,omitempty"`
+		OnError   FailPolicy        `yaml:"on_error,omitempty"`
+		Mode      LimiterMode       `yaml:"mode,omitempty"`
+		Type      AlgorithmType     `yaml:"type"`
+		Algorithm yaml.Node         `yaml:"algorithm"`
+		Tiers     []LimiterSettings `yaml:"tiers,omitempty"`
+		Name      string            `yaml:"name,omitempty"`
+		Costs     []CostSettings    `yaml:"costs,omitempty"`
+		Shadow    []LimiterSettings `yaml:"shadow,omitempty"`
+		Hybrid    *HybridSettings   `yaml:"hybrid,omitempty"`
+		Clients   string            `yaml:"clients,omitempty"`
+		Subnet    *SubnetSettings   `yaml:"subnet,omitempty"`
+
+		CountOnStatus  []string `yaml:"count_on_status,omitempty"`
+		RefundOnStatus []string `yaml:"refund_on_status,omitempty"`
+
+		Ban       *BanSettings       `yaml:"ban,omitempty"`
+		DenyCache *DenyCacheSettings `yaml:"deny_cache,omitempty"`
 	}
 	if err := n.Decode(&raw); err != nil {
 		return err
 	}
-	l.Type, l.Storage = raw.Type, raw.Storage
+	if raw.Storages != nil {
+		if raw.Storage != nil {
+			return fmt.Errorf("limiter cannot have both storage and deprecated storages")
+		}
+		raw.Storage = raw.Storages
+	}
+	l.Type, l.Storage, l.Key, l.OnError = raw.Type, raw.Storage, raw.Key, raw.OnError
+	l.Tiers, l.Name, l.Costs = raw.Tiers, raw.Name, raw.Costs
+	l.Mode, l.Shadow, l.Hybrid = raw.Mode, raw.Shadow, raw.Hybrid
+	l.Clients, l.Subnet, l.CountOnStatus = raw.Clients, raw.Subnet, raw.CountOnStatus
+	l.RefundOnStatus, l.Ban, l.DenyCache = raw.RefundOnStatus, raw.Ban, raw.DenyCache
+
+	if len(l.Tiers) > 0 {
+		if l.Type != "" {
+			return fmt.Errorf("limiter cannot have both type and tiers")
+		}
+		return nil
+	}
 
 	switch l.Type {
 	case FixedWindowAlgorithm:
@@ -95,6 +320,34 @@ func (l *LimiterSettings) UnmarshalYAML(node *yaml.Node) error {
 		}
 		l.Algorithm = &cfg
 
+	case GCRAAlgorithm:
+		var cfg GCRASettings
+		if err := raw.Algorithm.Decode(&cfg); err != nil {
+			return fmt.Errorf("failed to decode gcra algorithm: %w", err)
+		}
+		l.Algorithm = &cfg
+
+	case LeakyBucketAlgorithm:
+		var cfg LeakyBucketSettings
+		if err := raw.Algorithm.Decode(&cfg); err != nil {
+			return fmt.Errorf("failed to decode leaky_bucket algorithm: %w", err)
+		}
+		l.Algorithm = &cfg
+
+	case ConcurrencyAlgorithm:
+		var cfg ConcurrencySettings
+		if err := raw.Algorithm.Decode(&cfg); err != nil {
+			return fmt.Errorf("failed to decode concurrency algorithm: %w", err)
+		}
+		l.Algorithm = &cfg
+
+	case AdaptiveAlgorithm:
+		var cfg AdaptiveSettings
+		if err := raw.Algorithm.Decode(&cfg); err != nil {
+			return fmt.Errorf("failed to decode adaptive algorithm: %w", err)
+		}
+		l.Algorithm = &cfg
+
 	default:
 		return fmt.Errorf("unknown algorithm type: %s", l.Type)
 	}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestLimiterSettingsStorageAlias(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantTTL time.Duration
+		wantErr bool
+	}{
+		{
+			name:    "storage",
+			yaml:    "type: fixed_window\nstorage: {backend: memory, ttl: 1m}",
+			wantTTL: time.Minute,
+		},
+		{
+			name:    "deprecated storages",
+			yaml:    "type: fixed_window\nstorages: {backend: memory, ttl: 2m}",
+			wantTTL: 2 * time.Minute,
+		},
+		{
+			name:    "both",
+			yaml:    "type: fixed_window\nstorage: {ttl: 1m}\nstorages: {ttl: 2m}",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l LimiterSettings
+			err := yaml.Unmarshal([]byte(tt.yaml), &l)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if l.Storage == nil || l.Storage.KeyTTL != tt.wantTTL {
+				t.Errorf("Storage = %+v, want ttl %v", l.Storage, tt.wantTTL)
+			}
+		})
+	}
+}
@@ -6,13 +6,28 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-type UpstreamsAliases map[string]string
+type UpstreamAlias struct {
+	URL     string           `yaml:"url"`
+	Limiter *LimiterSettings `yaml:"limiter,omitempty"`
+}
+
+func (a *UpstreamAlias) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&a.URL)
+	}
+
+	type aliasSettings UpstreamAlias
+	return node.Decode((*aliasSettings)(a))
+}
+
+type UpstreamsAliases map[string]UpstreamAlias
 
 type Caches map[string]time.Duration
 
 type UpstreamSettings struct {
-	UpstreamAlias string  `yaml:"upstream"`
-	Cache         *Caches `yaml:"cache"`
+	UpstreamAlias string           `yaml:"upstream"`
+	Cache         *Caches          `yaml:"cache"`
+	Limiter       *LimiterSettings `yaml:"limiter,omitempty"`
 }
 
 type UpstreamDefault struct {
@@ -39,6 +54,7 @@ type Route struct {
 	Host    string           `yaml:"host"`
 	Paths   []Path           `yaml:"pathes"`
 	Default *UpstreamDefault `yaml:"default,omitempty"`
+	Limiter *LimiterSettings `yaml:"limiter,omitempty"`
 }
 
 type RouterSettings struct {
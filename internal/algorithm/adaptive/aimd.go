@@ -0,0 +1,33 @@
+package adaptive
+
+import (
+	"gateway/internal/limiter"
+	"time"
+)
+
+// Additive increase / multiplicative decrease: лимит растет на единицу,
+// пока ответы быстрые и успешные, и уменьшается в backoff раз при ошибке
+// или задержке больше latencyThreshold
+type aimd struct {
+	latencyThreshold time.Duration
+	backoff          float64
+}
+
+func NewAIMD(latencyThreshold time.Duration, backoff float64) *aimd {
+	return &aimd{
+		latencyThreshold: latencyThreshold,
+		backoff:          backoff,
+	}
+}
+
+func (c *aimd) Update(limit float64, s limiter.Sample) float64 {
+	if s.Failed || (c.latencyThreshold > 0 && s.Latency > c.latencyThreshold) {
+		return limit * c.backoff
+	}
+
+	// лимит увеличивается, только если он действительно используется
+	if float64(s.Inflight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
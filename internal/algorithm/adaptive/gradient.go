@@ -0,0 +1,59 @@
+package adaptive
+
+import (
+	"gateway/internal/limiter"
+	"math"
+)
+
+const (
+	longWindow  = 600
+	shortWindow = 10
+)
+
+// Градиентный контроллер в духе TCP Vegas: лимит масштабируется отношением
+// долгосрочной задержки к текущей, так что рост очереди у сервиса
+// уменьшает лимит раньше, чем появятся ошибки
+type gradient struct {
+	smoothing float64
+	backoff   float64
+
+	longRTT  float64
+	shortRTT float64
+}
+
+func NewGradient(smoothing, backoff float64) *gradient {
+	return &gradient{
+		smoothing: smoothing,
+		backoff:   backoff,
+	}
+}
+
+func (c *gradient) Update(limit float64, s limiter.Sample) float64 {
+	if s.Failed {
+		return limit * c.backoff
+	}
+
+	rtt := float64(s.Latency)
+	if c.longRTT == 0 {
+		c.longRTT, c.shortRTT = rtt, rtt
+		return limit
+	}
+	c.longRTT += (rtt - c.longRTT) / longWindow
+	c.shortRTT += (rtt - c.shortRTT) / shortWindow
+
+	// задержка снизилась - долгосрочное значение быстрее догоняет текущее
+	if c.longRTT > c.shortRTT*2 {
+		c.longRTT = c.shortRTT * 2
+	}
+
+	// пока лимит не используется наполовину, его нет смысла увеличивать
+	if float64(s.Inflight)*2 < limit {
+		return limit
+	}
+
+	grad := math.Max(0.5, math.Min(1, c.longRTT/c.shortRTT))
+	queue := math.Sqrt(limit)
+	newLimit := limit*grad + queue
+
+	return limit*(1-c.smoothing) + newLimit*c.smoothing
+}
@@ -0,0 +1,189 @@
+package algorithm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gateway/internal/limiter"
+	"math"
+	"time"
+)
+
+// Версия бинарного формата - первый байт состояния.
+// Состояние в JSON начинается с '{', поэтому форматы не пересекаются
+const codecVersion byte = 1
+
+func isJSON(data []byte) bool {
+	return len(data) > 0 && data[0] == '{'
+}
+
+// Компактная запись состояния: целые числа - varint,
+// время - unix nano, списки времени - разницы соседних значений
+type Encoder struct {
+	buf []byte
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{buf: []byte{codecVersion}}
+}
+
+func (e *Encoder) Bytes() []byte { return e.buf }
+
+func (e *Encoder) Int(v int64) *Encoder {
+	e.buf = binary.AppendVarint(e.buf, v)
+	return e
+}
+
+func (e *Encoder) Uint(v uint64) *Encoder {
+	e.buf = binary.AppendUvarint(e.buf, v)
+	return e
+}
+
+func (e *Encoder) Float(v float64) *Encoder {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+	return e
+}
+
+func (e *Encoder) String(s string) *Encoder {
+	e.Uint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+	return e
+}
+
+func (e *Encoder) Time(t time.Time) *Encoder {
+	return e.Int(unixNano(t))
+}
+
+func (e *Encoder) Times(ts []time.Time) *Encoder {
+	e.Uint(uint64(len(ts)))
+	var prev int64
+	for _, t := range ts {
+		n := unixNano(t)
+		e.Int(n - prev)
+		prev = n
+	}
+	return e
+}
+
+// Читает значения в порядке записи. Первая ошибка сохраняется,
+// после нее возвращаются нулевые значения
+type Decoder struct {
+	data []byte
+	err  error
+}
+
+func NewDecoder(data []byte) *Decoder {
+	d := &Decoder{}
+	switch {
+	case len(data) == 0:
+		d.err = fmt.Errorf("%w: empty state", limiter.ErrInvalidState)
+	case data[0] != codecVersion:
+		d.err = fmt.Errorf("%w: unsupported state version %d", limiter.ErrInvalidState, data[0])
+	default:
+		d.data = data[1:]
+	}
+	return d
+}
+
+func (d *Decoder) Err() error { return d.err }
+
+func (d *Decoder) Int() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *Decoder) Uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *Decoder) Float() float64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) < 8 {
+		d.fail()
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.data))
+	d.data = d.data[8:]
+	return v
+}
+
+func (d *Decoder) String() string {
+	n := d.Len()
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func (d *Decoder) Time() time.Time {
+	return fromUnixNano(d.Int())
+}
+
+func (d *Decoder) Times() []time.Time {
+	n := d.Len()
+	ts := make([]time.Time, 0, n)
+	var prev int64
+	for range n {
+		prev += d.Int()
+		ts = append(ts, fromUnixNano(prev))
+	}
+	if d.err != nil {
+		return nil
+	}
+	return ts
+}
+
+// Есть ли еще значения. Поля, добавленные в конец формата,
+// отсутствуют в состояниях, записанных раньше
+func (d *Decoder) More() bool {
+	return d.err == nil && len(d.data) > 0
+}
+
+// Длина списка или строки, не больше оставшихся байт
+func (d *Decoder) Len() int {
+	n := d.Uint()
+	if n > uint64(len(d.data)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *Decoder) fail() {
+	d.err = fmt.Errorf("%w: truncated state", limiter.ErrInvalidState)
+}
+
+// Нулевое время записывается как 0
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
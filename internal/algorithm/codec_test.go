@@ -0,0 +1,184 @@
+package algorithm_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gateway/internal/algorithm"
+	"gateway/internal/algorithm/concurrency"
+	"gateway/internal/algorithm/fixedwindow"
+	"gateway/internal/algorithm/gcra"
+	"gateway/internal/algorithm/leakybucket"
+	"gateway/internal/algorithm/slidingwindow"
+	"gateway/internal/algorithm/tokenbucket"
+	"gateway/internal/limiter"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Время после декодирования - time.Unix без монотонных часов
+var (
+	t0 = time.Unix(1_700_000_000, 123_456_789)
+	t1 = t0.Add(1500 * time.Millisecond)
+	t2 = t0.Add(3 * time.Second)
+)
+
+type codecCase struct {
+	name    string
+	params  limiter.Marshaler
+	unmarsh limiter.Unmarshaler[limiter.State]
+	// состояние в JSON, записанное до перехода на бинарный формат
+	json string
+	// длины начала состояния, которые сами по себе - состояние прежней версии формата
+	validPrefixes []int
+}
+
+func codecCases() []codecCase {
+	return []codecCase{
+		{
+			name:    "fixed_window",
+			params:  &fixedwindow.Params{WindowStart: t0, Count: 42},
+			unmarsh: algorithm.NewStateUnmarshaler[fixedwindow.Params](),
+			json:    `{"WindowStart":"2023-11-14T22:13:20.123456789Z","Count":42}`,
+		},
+		{
+			name:    "fixed_window zero",
+			params:  &fixedwindow.Params{},
+			unmarsh: algorithm.NewStateUnmarshaler[fixedwindow.Params](),
+		},
+		{
+			name:    "token_bucket",
+			params:  &tokenbucket.Params{Tokens: 7.25, LastUpdate: t0},
+			unmarsh: algorithm.NewStateUnmarshaler[tokenbucket.Params](),
+			json:    `{"Tokens":7.25,"LastUpdate":"2023-11-14T22:13:20.123456789Z"}`,
+		},
+		{
+			name:    "gcra",
+			params:  &gcra.Params{TAT: t0.UnixNano()},
+			unmarsh: algorithm.NewStateUnmarshaler[gcra.Params](),
+		},
+		{
+			name:    "leaky_bucket",
+			params:  &leakybucket.Params{Next: t0},
+			unmarsh: algorithm.NewStateUnmarshaler[leakybucket.Params](),
+		},
+		{
+			name:    "sliding_window_counter",
+			params:  &slidingwindow.CounterParams{Buckets: []int64{3, 0, 9}, BucketTimes: []time.Time{t0, t1, t2}, CurrentIndex: 2},
+			unmarsh: algorithm.NewStateUnmarshaler[slidingwindow.CounterParams](),
+			json: `{"Buckets":[3,0,9],"BucketTimes":["2023-11-14T22:13:20.123456789Z",` +
+				`"2023-11-14T22:13:21.623456789Z","2023-11-14T22:13:23.123456789Z"],"CurrentIndex":2}`,
+		},
+		{
+			name:    "sliding_window_log",
+			params:  &slidingwindow.LogParams{Logs: []time.Time{t0, t1, t2}, Counts: []int{1, 5, 2}},
+			unmarsh: algorithm.NewStateUnmarshaler[slidingwindow.LogParams](),
+			// журнал без Counts записывался до стоимости запросов
+			validPrefixes: []int{len(algorithm.NewEncoder().Times([]time.Time{t0, t1, t2}).Bytes())},
+		},
+		{
+			name: "sliding_window_log old",
+			// пустой Counts записывается, а читается как пустой список
+			params:        &slidingwindow.LogParams{Logs: []time.Time{t0, t1}, Counts: []int{}},
+			unmarsh:       algorithm.NewStateUnmarshaler[slidingwindow.LogParams](),
+			json:          `{"Logs":["2023-11-14T22:13:20.123456789Z","2023-11-14T22:13:21.623456789Z"]}`,
+			validPrefixes: []int{len(algorithm.NewEncoder().Times([]time.Time{t0, t1}).Bytes())},
+		},
+		{
+			name:    "concurrency",
+			params:  &concurrency.Params{Leases: map[string]int64{"a1b2": t0.UnixNano()}},
+			unmarsh: algorithm.NewStateUnmarshaler[concurrency.Params](),
+		},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, tt := range codecCases() {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.params.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			st, err := tt.unmarsh.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(st.Params, tt.params) {
+				t.Errorf("Unmarshal() = %+v, want %+v", st.Params, tt.params)
+			}
+		})
+	}
+}
+
+// Состояния в JSON, записанные прежними версиями шлюза, читаются
+func TestCodecDecodesJSON(t *testing.T) {
+	for _, tt := range codecCases() {
+		data := []byte(tt.json)
+		if tt.json == "" {
+			var err error
+			if data, err = json.Marshal(tt.params); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := tt.unmarsh.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			// время из JSON в другой зоне, поэтому сравнивается бинарная запись
+			got, err := st.Params.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := tt.params.Marshal()
+			if !bytes.Equal(got, want) {
+				t.Errorf("Unmarshal() = %+v, want %+v", st.Params, tt.params)
+			}
+		})
+	}
+}
+
+func TestCodecRejectsInvalidState(t *testing.T) {
+	for _, tt := range codecCases() {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.params.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			invalid := map[string][]byte{"empty": nil}
+			for n := 1; n < len(data); n++ {
+				if !slices.Contains(tt.validPrefixes, n) {
+					invalid[fmt.Sprintf("truncated to %d bytes", n)] = data[:n]
+				}
+			}
+			invalid["unknown version"] = append([]byte{data[0] + 1}, data[1:]...)
+			if tt.json != "" {
+				invalid["truncated json"] = []byte(tt.json[:len(tt.json)/2])
+			}
+
+			for name, data := range invalid {
+				st, err := tt.unmarsh.Unmarshal(data)
+				if !errors.Is(err, limiter.ErrInvalidState) {
+					t.Errorf("%s: Unmarshal(%v) = %+v, %v, want ErrInvalidState", name, data, st, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDecoderLenIsBounded(t *testing.T) {
+	// длина списка больше оставшихся байт не должна выделять память под список
+	data := algorithm.NewEncoder().Uint(1 << 40).Bytes()
+	d := algorithm.NewDecoder(data)
+	if n := d.Len(); n != 0 || !errors.Is(d.Err(), limiter.ErrInvalidState) {
+		t.Errorf("Len() = %d, err = %v, want 0 and ErrInvalidState", n, d.Err())
+	}
+	if ts := d.Times(); ts != nil {
+		t.Errorf("Times() after error = %v, want nil", ts)
+	}
+}
@@ -0,0 +1,113 @@
+package concurrency
+
+import (
+	"gateway/internal/algorithm"
+	"gateway/internal/limiter"
+	"time"
+)
+
+const defaultLeaseTTL = 30 * time.Second
+
+type Params struct {
+	// идентификатор аренды -> время истечения, unix nano
+	Leases map[string]int64
+}
+
+func (p *Params) Marshal() ([]byte, error) {
+	e := algorithm.NewEncoder().Uint(uint64(len(p.Leases)))
+	for id, expires := range p.Leases {
+		e.String(id).Int(expires)
+	}
+	return e.Bytes(), nil
+}
+
+func (p *Params) UnmarshalBinary(data []byte) error {
+	d := algorithm.NewDecoder(data)
+	n := d.Len()
+	p.Leases = make(map[string]int64, n)
+	for range n {
+		id := d.String()
+		p.Leases[id] = d.Int()
+	}
+	return d.Err()
+}
+
+type concurrency struct {
+	limit    int
+	leaseTTL time.Duration
+	clock    limiter.Clock
+}
+
+// limit - одновременно выполняемых запросов,
+// leaseTTL - время, через которое слот освобождается, если его не продлили (по умолчанию 30s)
+func NewConcurrency(limit int, leaseTTL time.Duration, clock limiter.Clock) *concurrency {
+	if leaseTTL <= 0 {
+		leaseTTL = defaultLeaseTTL
+	}
+	return &concurrency{
+		clock:    clock,
+		limit:    limit,
+		leaseTTL: leaseTTL,
+	}
+}
+
+func (c *concurrency) LeaseTTL() time.Duration { return c.leaseTTL }
+
+func (c *concurrency) FirstState() *limiter.State {
+	return &limiter.State{Params: &Params{map[string]int64{}}}
+}
+
+func (c *concurrency) Acquire(state *limiter.State, id string) (bool, *limiter.State, error) {
+	p, err := c.params(state)
+	if err != nil {
+		return false, nil, err
+	}
+
+	now := c.clock.Now()
+	for lease, expire := range p.Leases {
+		if expire <= now.UnixNano() {
+			delete(p.Leases, lease)
+		}
+	}
+
+	if len(p.Leases) >= c.limit {
+		return false, &limiter.State{Params: p}, nil
+	}
+	p.Leases[id] = now.Add(c.leaseTTL).UnixNano()
+	return true, &limiter.State{Params: p}, nil
+}
+
+// Продлевает аренду, если она еще не истекла
+func (c *concurrency) Renew(state *limiter.State, id string) (*limiter.State, error) {
+	p, err := c.params(state)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.clock.Now()
+	if expire, ok := p.Leases[id]; ok && expire > now.UnixNano() {
+		p.Leases[id] = now.Add(c.leaseTTL).UnixNano()
+	}
+	return &limiter.State{Params: p}, nil
+}
+
+func (c *concurrency) Release(state *limiter.State, id string) (*limiter.State, error) {
+	p, err := c.params(state)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(p.Leases, id)
+	return &limiter.State{Params: p}, nil
+}
+
+func (c *concurrency) params(state *limiter.State) (*Params, error) {
+	p, ok := state.Params.(*Params)
+	if !ok {
+		return nil, limiter.ErrInvalidState
+	}
+	if p.Leases == nil {
+		p.Leases = map[string]int64{}
+	}
+	return p, nil
+}
@@ -1,8 +1,9 @@
 package fixedwindow
 
 import (
-	"encoding/json"
+	"gateway/internal/algorithm"
 	"gateway/internal/limiter"
+	"gateway/server/interfaces"
 	"time"
 )
 
@@ -11,32 +12,54 @@ type Params struct {
 	Count       int
 }
 
-func (p *Params) Marshal() ([]byte, error) { return json.Marshal(p) }
+func (p *Params) Marshal() ([]byte, error) {
+	return algorithm.NewEncoder().Time(p.WindowStart).Int(int64(p.Count)).Bytes(), nil
+}
+
+func (p *Params) UnmarshalBinary(data []byte) error {
+	d := algorithm.NewDecoder(data)
+	p.WindowStart, p.Count = d.Time(), int(d.Int())
+	return d.Err()
+}
 
 type fixedWindow struct {
 	limit     int
 	windowDur time.Duration
+	clock     limiter.Clock
 }
 
-func NewFixedWindow(limit int, windowDur time.Duration) *fixedWindow {
+func NewFixedWindow(limit int, windowDur time.Duration, clock limiter.Clock) *fixedWindow {
 	return &fixedWindow{
+		clock:     clock,
 		limit:     limit,
 		windowDur: windowDur,
 	}
 }
 
 func (fw *fixedWindow) FirstState() *limiter.State {
-	return &limiter.State{Params: &Params{time.Now(), 0}}
+	return &limiter.State{Params: &Params{fw.clock.Now(), 0}}
+}
+
+// Квота возвращается, только если окно, в котором она выдана, еще идет
+func (fw *fixedWindow) Refund(state *limiter.State, cost int, reserved interfaces.Decision) (*limiter.State, error) {
+	p, ok := state.Params.(*Params)
+	if !ok {
+		return nil, limiter.ErrInvalidState
+	}
+	if p.WindowStart.Add(fw.windowDur).Equal(reserved.Reset) {
+		p.Count = max(p.Count-cost, 0)
+	}
+	return &limiter.State{Params: p}, nil
 }
 
-func (fw *fixedWindow) Action(state *limiter.State) (bool, *limiter.State, error) {
+func (fw *fixedWindow) Action(state *limiter.State, cost int) (interfaces.Decision, *limiter.State, error) {
 	p, ok := state.Params.(*Params)
 	if !ok {
-		return false, nil, limiter.ErrInvalidState
+		return interfaces.Decision{}, nil, limiter.ErrInvalidState
 	}
 
 	count, windowStart := p.Count, p.WindowStart
-	now := time.Now()
+	now := fw.clock.Now()
 
 	if now.Sub(windowStart) >= fw.windowDur {
 		windowStart = now.Truncate(fw.windowDur)
@@ -44,11 +67,22 @@ func (fw *fixedWindow) Action(state *limiter.State) (bool, *limiter.State, error
 	}
 
 	allow := false
-	if count < fw.limit {
-		count++
+	if count+cost <= fw.limit {
+		count += cost
 		allow = true
 	}
-	return allow, &limiter.State{
+
+	reset := windowStart.Add(fw.windowDur)
+	d := interfaces.Decision{
+		Allowed:   allow,
+		Limit:     int64(fw.limit),
+		Remaining: int64(fw.limit - count),
+		Reset:     reset,
+	}
+	if !allow {
+		d.RetryAfter = reset.Sub(now)
+	}
+	return d, &limiter.State{
 		Params: &Params{
 			WindowStart: windowStart,
 			Count:       count,
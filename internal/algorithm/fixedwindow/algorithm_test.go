@@ -0,0 +1,87 @@
+package fixedwindow
+
+import (
+	"gateway/internal/clock"
+	"testing"
+	"time"
+)
+
+// Время, кратное минуте: границы окон совпадают с началом теста
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type step struct {
+	advance    time.Duration
+	cost       int
+	allowed    bool
+	retryAfter time.Duration
+}
+
+func TestFixedWindowBoundaries(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "limit within window",
+			steps: []step{
+				{cost: 1, allowed: true},
+				{cost: 1, allowed: true},
+				{cost: 1, allowed: true},
+				{cost: 1, retryAfter: time.Minute},
+			},
+		},
+		{
+			name: "count resets at window edge",
+			steps: []step{
+				{cost: 3, allowed: true},
+				{advance: time.Minute - time.Millisecond, cost: 1, retryAfter: time.Millisecond},
+				{advance: time.Millisecond, cost: 3, allowed: true},
+				{cost: 1, retryAfter: time.Minute},
+			},
+		},
+		{
+			name: "cost above remaining is rejected without spending quota",
+			steps: []step{
+				{cost: 2, allowed: true},
+				{cost: 2, retryAfter: time.Minute},
+				{cost: 1, allowed: true},
+			},
+		},
+		{
+			name: "window after idle period starts at minute boundary",
+			steps: []step{
+				{advance: 90 * time.Second, cost: 3, allowed: true},
+				{cost: 1, retryAfter: 30 * time.Second},
+				{advance: 30 * time.Second, cost: 1, allowed: true},
+			},
+		},
+		{
+			name: "cost above limit is never allowed",
+			steps: []step{
+				{cost: 4, retryAfter: time.Minute},
+				{advance: time.Minute, cost: 4, retryAfter: time.Minute},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(start)
+			fw := NewFixedWindow(3, time.Minute, clk)
+
+			state := fw.FirstState()
+			for i, s := range tt.steps {
+				clk.Advance(s.advance)
+				d, next, err := fw.Action(state, s.cost)
+				if err != nil {
+					t.Fatalf("step %d: Action() error = %v", i, err)
+				}
+				if d.Allowed != s.allowed || d.RetryAfter != s.retryAfter {
+					t.Errorf("step %d: allowed = %v, retryAfter = %v, want %v, %v",
+						i, d.Allowed, d.RetryAfter, s.allowed, s.retryAfter)
+				}
+				state = next
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package gcra
+
+import (
+	"gateway/internal/algorithm"
+	"gateway/internal/limiter"
+	"gateway/server/interfaces"
+	"time"
+)
+
+type Params struct {
+	// теоретическое время прибытия следующего запроса, unix nano
+	TAT int64
+}
+
+func (p *Params) Marshal() ([]byte, error) {
+	return algorithm.NewEncoder().Int(p.TAT).Bytes(), nil
+}
+
+func (p *Params) UnmarshalBinary(data []byte) error {
+	d := algorithm.NewDecoder(data)
+	p.TAT = d.Int()
+	return d.Err()
+}
+
+type gcra struct {
+	burst int
+
+	// интервал между запросами при равномерном потоке
+	emission time.Duration
+	// насколько TAT может опережать текущее время
+	tolerance time.Duration
+	clock     limiter.Clock
+}
+
+// limit запросов за period, burst - сколько запросов можно выполнить подряд.
+// limit > 0 и period >= limit наносекунд: иначе интервал между запросами нулевой
+func NewGCRA(limit int, period time.Duration, burst int, clock limiter.Clock) *gcra {
+	if burst < 1 {
+		burst = 1
+	}
+	emission := period / time.Duration(limit)
+	return &gcra{
+		clock:     clock,
+		burst:     burst,
+		emission:  emission,
+		tolerance: emission * time.Duration(burst),
+	}
+}
+
+func (g *gcra) FirstState() *limiter.State {
+	return &limiter.State{Params: &Params{g.clock.Now().UnixNano()}}
+}
+
+// TAT сдвигается назад, но не раньше текущего времени
+func (g *gcra) Refund(state *limiter.State, cost int, _ interfaces.Decision) (*limiter.State, error) {
+	p, ok := state.Params.(*Params)
+	if !ok {
+		return nil, limiter.ErrInvalidState
+	}
+	tat := time.Unix(0, p.TAT).Add(-g.emission * time.Duration(cost))
+	p.TAT = max(tat.UnixNano(), g.clock.Now().UnixNano())
+	return &limiter.State{Params: p}, nil
+}
+
+func (g *gcra) Action(state *limiter.State, cost int) (interfaces.Decision, *limiter.State, error) {
+	p, ok := state.Params.(*Params)
+	if !ok {
+		return interfaces.Decision{}, nil, limiter.ErrInvalidState
+	}
+
+	now := g.clock.Now()
+	tat := time.Unix(0, p.TAT)
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTAT := tat.Add(g.emission * time.Duration(cost))
+	if newTAT.Sub(now) > g.tolerance {
+		d := g.decision(false, tat, now)
+		d.RetryAfter = newTAT.Sub(now) - g.tolerance
+		return d, &limiter.State{Params: p}, nil
+	}
+
+	p.TAT = newTAT.UnixNano()
+	return g.decision(true, newTAT, now), &limiter.State{Params: p}, nil
+}
+
+func (g *gcra) decision(allow bool, tat, now time.Time) interfaces.Decision {
+	return interfaces.Decision{
+		Allowed:   allow,
+		Limit:     int64(g.burst),
+		Remaining: max(int64((g.tolerance-tat.Sub(now))/g.emission), 0),
+		Reset:     tat,
+	}
+}
@@ -0,0 +1,78 @@
+package gcra
+
+import (
+	"gateway/internal/clock"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type step struct {
+	advance    time.Duration
+	cost       int
+	allowed    bool
+	retryAfter time.Duration
+}
+
+// 10 запросов в секунду (интервал 100ms), подряд не больше 2
+func TestGCRABoundaries(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "burst then emission interval",
+			steps: []step{
+				{cost: 1, allowed: true},
+				{cost: 1, allowed: true},
+				{cost: 1, retryAfter: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "request allowed exactly at emission edge",
+			steps: []step{
+				{cost: 2, allowed: true},
+				{advance: 99 * time.Millisecond, cost: 1, retryAfter: time.Millisecond},
+				{advance: time.Millisecond, cost: 1, allowed: true},
+				{cost: 1, retryAfter: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "idle time does not accumulate beyond burst",
+			steps: []step{
+				{advance: time.Hour, cost: 1, allowed: true},
+				{cost: 1, allowed: true},
+				{cost: 1, retryAfter: 100 * time.Millisecond},
+			},
+		},
+		{
+			name: "cost above burst is never allowed",
+			steps: []step{
+				{cost: 3, retryAfter: 100 * time.Millisecond},
+				{advance: time.Hour, cost: 3, retryAfter: 100 * time.Millisecond},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(start)
+			g := NewGCRA(10, time.Second, 2, clk)
+
+			state := g.FirstState()
+			for i, s := range tt.steps {
+				clk.Advance(s.advance)
+				d, next, err := g.Action(state, s.cost)
+				if err != nil {
+					t.Fatalf("step %d: Action() error = %v", i, err)
+				}
+				if d.Allowed != s.allowed || d.RetryAfter != s.retryAfter {
+					t.Errorf("step %d: allowed = %v, retryAfter = %v, want %v, %v",
+						i, d.Allowed, d.RetryAfter, s.allowed, s.retryAfter)
+				}
+				state = next
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package leakybucket
+
+import (
+	"gateway/internal/algorithm"
+	"gateway/internal/limiter"
+	"gateway/server/interfaces"
+	"time"
+)
+
+type Params struct {
+	// время, когда очередь освободится для следующего запроса
+	Next time.Time
+}
+
+func (p *Params) Marshal() ([]byte, error) {
+	return algorithm.NewEncoder().Time(p.Next).Bytes(), nil
+}
+
+func (p *Params) UnmarshalBinary(data []byte) error {
+	d := algorithm.NewDecoder(data)
+	p.Next = d.Time()
+	return d.Err()
+}
+
+type leakyBucket struct {
+	interval time.Duration
+	maxDelay time.Duration
+	clock    limiter.Clock
+}
+
+// rate - запросов в секунду на выходе, maxDelay - максимальное время ожидания в очереди
+func NewLeakyBucket(rate float64, maxDelay time.Duration, clock limiter.Clock) *leakyBucket {
+	return &leakyBucket{
+		clock:    clock,
+		interval: time.Duration(float64(time.Second) / rate),
+		maxDelay: maxDelay,
+	}
+}
+
+func (lb *leakyBucket) FirstState() *limiter.State {
+	return &limiter.State{Params: &Params{lb.clock.Now()}}
+}
+
+// Пропускает запрос, только если его не нужно задерживать
+func (lb *leakyBucket) Action(state *limiter.State, cost int) (interfaces.Decision, *limiter.State, error) {
+	p, ok := state.Params.(*Params)
+	if !ok {
+		return interfaces.Decision{}, nil, limiter.ErrInvalidState
+	}
+
+	now := lb.clock.Now()
+	if p.Next.After(now) {
+		d := interfaces.Decision{Allowed: false, Limit: 1, Reset: p.Next}
+		d.RetryAfter = p.Next.Sub(now)
+		return d, &limiter.State{Params: p}, nil
+	}
+
+	p.Next = now.Add(lb.interval * time.Duration(cost))
+	d := interfaces.Decision{Allowed: true, Limit: 1, Reset: p.Next}
+	return d, &limiter.State{Params: p}, nil
+}
+
+func (lb *leakyBucket) Schedule(state *limiter.State, cost int) (time.Time, interfaces.Decision, *limiter.State, error) {
+	p, ok := state.Params.(*Params)
+	if !ok {
+		return time.Time{}, interfaces.Decision{}, nil, limiter.ErrInvalidState
+	}
+
+	now := lb.clock.Now()
+	start := p.Next
+	if start.Before(now) {
+		start = now
+	}
+
+	if start.Sub(now) > lb.maxDelay {
+		d := lb.decision(false, p.Next, now)
+		d.RetryAfter = start.Sub(now) - lb.maxDelay
+		return time.Time{}, d, &limiter.State{Params: p}, nil
+	}
+
+	p.Next = start.Add(lb.interval * time.Duration(cost))
+	return start, lb.decision(true, p.Next, now), &limiter.State{Params: p}, nil
+}
+
+// Освобождает место запроса в очереди: следующие запросы ждут меньше.
+// Очередь не сдвигается раньше текущего времени
+func (lb *leakyBucket) Refund(state *limiter.State, cost int, _ interfaces.Decision) (*limiter.State, error) {
+	p, ok := state.Params.(*Params)
+	if !ok {
+		return nil, limiter.ErrInvalidState
+	}
+
+	now := lb.clock.Now()
+	p.Next = p.Next.Add(-lb.interval * time.Duration(cost))
+	if p.Next.Before(now) {
+		p.Next = now
+	}
+	return &limiter.State{Params: p}, nil
+}
+
+// Квота - места в очереди, которые можно занять, не превысив maxDelay
+func (lb *leakyBucket) decision(allow bool, next, now time.Time) interfaces.Decision {
+	queued := max(next.Sub(now), 0)
+	d := interfaces.Decision{
+		Allowed: allow,
+		Limit:   int64(lb.maxDelay/lb.interval) + 1,
+		Reset:   next,
+	}
+	if queued <= lb.maxDelay {
+		d.Remaining = int64((lb.maxDelay-queued)/lb.interval) + 1
+	}
+	return d
+}
@@ -0,0 +1,160 @@
+package leakybucket
+
+import (
+	"gateway/internal/clock"
+	"gateway/server/interfaces"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Без задержки: запрос проходит, только если очередь пуста
+func TestLeakyBucketActionBoundaries(t *testing.T) {
+	type step struct {
+		advance    time.Duration
+		cost       int
+		allowed    bool
+		retryAfter time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "one request per interval",
+			steps: []step{
+				{cost: 1, allowed: true},
+				{cost: 1, retryAfter: 100 * time.Millisecond},
+				{advance: 99 * time.Millisecond, cost: 1, retryAfter: time.Millisecond},
+				{advance: time.Millisecond, cost: 1, allowed: true},
+			},
+		},
+		{
+			name: "cost occupies several intervals",
+			steps: []step{
+				{cost: 3, allowed: true},
+				{advance: 200 * time.Millisecond, cost: 1, retryAfter: 100 * time.Millisecond},
+				{advance: 100 * time.Millisecond, cost: 1, allowed: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(start)
+			lb := NewLeakyBucket(10, 200*time.Millisecond, clk)
+
+			state := lb.FirstState()
+			for i, s := range tt.steps {
+				clk.Advance(s.advance)
+				d, next, err := lb.Action(state, s.cost)
+				if err != nil {
+					t.Fatalf("step %d: Action() error = %v", i, err)
+				}
+				if d.Allowed != s.allowed || d.RetryAfter != s.retryAfter {
+					t.Errorf("step %d: allowed = %v, retryAfter = %v, want %v, %v",
+						i, d.Allowed, d.RetryAfter, s.allowed, s.retryAfter)
+				}
+				state = next
+			}
+		})
+	}
+}
+
+// Запросы задерживаются, пока задержка не превышает maxDelay
+func TestLeakyBucketScheduleBoundaries(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		cost    int
+		allowed bool
+		// задержка запроса или, для отклоненного, RetryAfter
+		wait time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "queue up to max delay",
+			steps: []step{
+				{cost: 1, allowed: true},
+				{cost: 1, allowed: true, wait: 100 * time.Millisecond},
+				{cost: 1, allowed: true, wait: 200 * time.Millisecond},
+				{cost: 1, wait: 100 * time.Millisecond},
+				{advance: 100 * time.Millisecond, cost: 1, allowed: true, wait: 200 * time.Millisecond},
+			},
+		},
+		{
+			name: "queue drains while idle",
+			steps: []step{
+				{cost: 3, allowed: true},
+				{advance: time.Second, cost: 1, allowed: true},
+				{cost: 1, allowed: true, wait: 100 * time.Millisecond},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(start)
+			lb := NewLeakyBucket(10, 200*time.Millisecond, clk)
+
+			state := lb.FirstState()
+			for i, s := range tt.steps {
+				clk.Advance(s.advance)
+				until, d, next, err := lb.Schedule(state, s.cost)
+				if err != nil {
+					t.Fatalf("step %d: Schedule() error = %v", i, err)
+				}
+
+				wait := d.RetryAfter
+				if d.Allowed {
+					wait = until.Sub(clk.Now())
+				}
+				if d.Allowed != s.allowed || wait != s.wait {
+					t.Errorf("step %d: allowed = %v, wait = %v, want %v, %v", i, d.Allowed, wait, s.allowed, s.wait)
+				}
+				state = next
+			}
+		})
+	}
+}
+
+// Возврат места сокращает очередь, но не сдвигает ее в прошлое
+func TestLeakyBucketRefund(t *testing.T) {
+	clk := clock.NewFake(start)
+	lb := NewLeakyBucket(10, 200*time.Millisecond, clk)
+
+	state := lb.FirstState()
+	for range 3 {
+		_, _, next, err := lb.Schedule(state, 1)
+		if err != nil {
+			t.Fatalf("Schedule() error = %v", err)
+		}
+		state = next
+	}
+
+	steps := []struct {
+		advance time.Duration
+		cost    int
+		next    time.Time
+	}{
+		{cost: 1, next: start.Add(200 * time.Millisecond)},
+		{advance: 150 * time.Millisecond, cost: 1, next: start.Add(150 * time.Millisecond)},
+		{cost: 1, next: start.Add(150 * time.Millisecond)},
+	}
+	for i, s := range steps {
+		clk.Advance(s.advance)
+		next, err := lb.Refund(state, s.cost, interfaces.Decision{})
+		if err != nil {
+			t.Fatalf("step %d: Refund() error = %v", i, err)
+		}
+		if got := next.Params.(*Params).Next; !got.Equal(s.next) {
+			t.Errorf("step %d: next = %v, want %v", i, got, s.next)
+		}
+		state = next
+	}
+}
@@ -1,8 +1,9 @@
 package slidingwindow
 
 import (
-	"encoding/json"
+	"gateway/internal/algorithm"
 	"gateway/internal/limiter"
+	"gateway/server/interfaces"
 	"time"
 )
 
@@ -12,17 +13,36 @@ type CounterParams struct {
 	CurrentIndex int
 }
 
-func (p CounterParams) Marshal() ([]byte, error) { return json.Marshal(p) }
+func (p CounterParams) Marshal() ([]byte, error) {
+	e := algorithm.NewEncoder().Uint(uint64(len(p.Buckets)))
+	for _, b := range p.Buckets {
+		e.Int(b)
+	}
+	return e.Times(p.BucketTimes).Int(int64(p.CurrentIndex)).Bytes(), nil
+}
+
+func (p *CounterParams) UnmarshalBinary(data []byte) error {
+	d := algorithm.NewDecoder(data)
+	n := d.Len()
+	p.Buckets = make([]int64, n)
+	for i := range n {
+		p.Buckets[i] = d.Int()
+	}
+	p.BucketTimes, p.CurrentIndex = d.Times(), int(d.Int())
+	return d.Err()
+}
 
 type slidingWindowCounter struct {
 	windowSize time.Duration
 	bucketSize time.Duration
 	bucketsNum int
 	limit      int64
+	clock      limiter.Clock
 }
 
-func NewSlidingWindowCounter(window time.Duration, bucketsNum int, limit int64) *slidingWindowCounter {
+func NewSlidingWindowCounter(window time.Duration, bucketsNum int, limit int64, clock limiter.Clock) *slidingWindowCounter {
 	return &slidingWindowCounter{
+		clock:      clock,
 		windowSize: window,
 		bucketSize: window / time.Duration(bucketsNum),
 		bucketsNum: bucketsNum,
@@ -40,13 +60,13 @@ func (sw *slidingWindowCounter) FirstState() *limiter.State {
 	}
 }
 
-func (sw *slidingWindowCounter) Action(state *limiter.State) (bool, *limiter.State, error) {
+func (sw *slidingWindowCounter) Action(state *limiter.State, cost int) (interfaces.Decision, *limiter.State, error) {
 	p, ok := state.Params.(*CounterParams)
 	if !ok {
-		return false, nil, limiter.ErrInvalidState
+		return interfaces.Decision{}, nil, limiter.ErrInvalidState
 	}
 
-	now := time.Now()
+	now := sw.clock.Now()
 	currentBucketStart := now.Truncate(sw.bucketSize)
 
 	targetIndex := -1
@@ -65,30 +85,44 @@ func (sw *slidingWindowCounter) Action(state *limiter.State) (bool, *limiter.Sta
 		p.BucketTimes[targetIndex] = currentBucketStart
 	}
 
+	// бакет перестает учитываться, когда его начало выходит за окно
 	cutoff := now.Add(-sw.windowSize)
 	for i := 0; i < sw.bucketsNum; i++ {
-		if !p.BucketTimes[i].IsZero() && p.BucketTimes[i].Before(cutoff) {
+		if !p.BucketTimes[i].IsZero() && !p.BucketTimes[i].After(cutoff) {
 			p.Buckets[i] = 0
 			p.BucketTimes[i] = time.Time{}
 		}
 	}
 
-	var total int64
+	var (
+		total  int64
+		oldest time.Time
+	)
 	for i := 0; i < sw.bucketsNum; i++ {
 		start := p.BucketTimes[i]
 		if start.IsZero() {
 			continue
 		}
-		end := start.Add(sw.bucketSize)
-		if end.After(cutoff) {
-			total += p.Buckets[i]
+		total += p.Buckets[i]
+		if p.Buckets[i] > 0 && (oldest.IsZero() || start.Before(oldest)) {
+			oldest = start
 		}
 	}
 
-	if total >= sw.limit {
-		return false, &limiter.State{Params: p}, nil
+	allow := total+int64(cost) <= sw.limit
+	if allow {
+		p.Buckets[targetIndex] += int64(cost)
+		total += int64(cost)
 	}
 
-	p.Buckets[targetIndex]++
-	return true, &limiter.State{Params: p}, nil
+	d := interfaces.Decision{
+		Allowed:   allow,
+		Limit:     sw.limit,
+		Remaining: max(sw.limit-total, 0),
+		Reset:     currentBucketStart.Add(sw.windowSize),
+	}
+	if !allow && !oldest.IsZero() {
+		d.RetryAfter = oldest.Add(sw.windowSize).Sub(now)
+	}
+	return d, &limiter.State{Params: p}, nil
 }
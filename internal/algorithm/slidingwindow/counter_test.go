@@ -0,0 +1,78 @@
+package slidingwindow
+
+import (
+	"gateway/internal/clock"
+	"testing"
+	"time"
+)
+
+// Окно в минуту из 6 бакетов по 10s, лимит 3
+func TestSlidingWindowCounterBoundaries(t *testing.T) {
+	type step struct {
+		advance    time.Duration
+		cost       int
+		allowed    bool
+		retryAfter time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "limit within window",
+			steps: []step{
+				{cost: 1, allowed: true},
+				{cost: 2, allowed: true},
+				{cost: 1, retryAfter: time.Minute},
+			},
+		},
+		{
+			name: "bucket leaves window with its start",
+			steps: []step{
+				{cost: 3, allowed: true},
+				{advance: time.Minute - time.Millisecond, cost: 1, retryAfter: time.Millisecond},
+				{advance: time.Millisecond, cost: 3, allowed: true},
+			},
+		},
+		{
+			name: "requests spread across buckets",
+			steps: []step{
+				{cost: 1, allowed: true},
+				{advance: 15 * time.Second, cost: 1, allowed: true},
+				{advance: 15 * time.Second, cost: 1, allowed: true},
+				{cost: 1, retryAfter: 30 * time.Second},
+				{advance: 30 * time.Second, cost: 1, allowed: true},
+				// запрос в t+15s учтен в бакете t+10s и освобождает квоту вместе с ним
+				{cost: 1, retryAfter: 10 * time.Second},
+			},
+		},
+		{
+			name: "cost above limit is rejected without retry time",
+			steps: []step{
+				{cost: 4},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+			sw := NewSlidingWindowCounter(time.Minute, 6, 3, clk)
+
+			state := sw.FirstState()
+			for i, s := range tt.steps {
+				clk.Advance(s.advance)
+				d, next, err := sw.Action(state, s.cost)
+				if err != nil {
+					t.Fatalf("step %d: Action() error = %v", i, err)
+				}
+				if d.Allowed != s.allowed || d.RetryAfter != s.retryAfter {
+					t.Errorf("step %d: allowed = %v, retryAfter = %v, want %v, %v",
+						i, d.Allowed, d.RetryAfter, s.allowed, s.retryAfter)
+				}
+				state = next
+			}
+		})
+	}
+}
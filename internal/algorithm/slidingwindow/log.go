@@ -1,56 +1,160 @@
 package slidingwindow
 
 import (
-	"encoding/json"
+	"gateway/internal/algorithm"
 	"gateway/internal/limiter"
+	"gateway/server/interfaces"
 	"sort"
 	"time"
 )
 
+// Записей в журнале не больше maxLogEntries+1, сколько бы ни был лимит
+const maxLogEntries = 1024
+
+// Журнал запросов. Запросы из одного интервала окна длиной window/maxLogEntries
+// хранятся одной записью со временем последнего из них и числом запросов
 type LogParams struct {
 	Logs []time.Time
+	// пустой - по одному запросу в записи (состояния прежних версий)
+	Counts []int
+}
+
+func (p LogParams) Marshal() ([]byte, error) {
+	e := algorithm.NewEncoder().Times(p.Logs).Uint(uint64(len(p.Counts)))
+	for _, c := range p.Counts {
+		e.Uint(uint64(c))
+	}
+	return e.Bytes(), nil
+}
+
+func (p *LogParams) UnmarshalBinary(data []byte) error {
+	d := algorithm.NewDecoder(data)
+	p.Logs = d.Times()
+	if d.More() {
+		p.Counts = make([]int, d.Len())
+		for i := range p.Counts {
+			p.Counts[i] = int(d.Uint())
+		}
+	}
+	return d.Err()
 }
 
-func (p LogParams) Marshal() ([]byte, error) { return json.Marshal(p) }
+func (p *LogParams) normalize() {
+	if len(p.Counts) == len(p.Logs) {
+		return
+	}
+	p.Counts = make([]int, len(p.Logs))
+	for i := range p.Counts {
+		p.Counts[i] = 1
+	}
+}
 
 type slidingWindowLog struct {
-	windowDur time.Duration
-	limit     int
+	windowDur  time.Duration
+	limit      int
+	resolution time.Duration
+	clock      limiter.Clock
 }
 
-func newSlidingWindowLog(limit int, windowDur time.Duration) *slidingWindowLog {
+// Точный учет запросов за последние windowDur. Запрос из записи истекает
+// по времени последнего запроса записи, поэтому лимит не превышается,
+// а квота может освободиться позже на время до windowDur/maxLogEntries
+func NewSlidingWindowLog(limit int, windowDur time.Duration, clock limiter.Clock) *slidingWindowLog {
 	return &slidingWindowLog{
-		windowDur: windowDur, limit: limit,
+		clock:      clock,
+		windowDur:  windowDur,
+		limit:      limit,
+		resolution: max(windowDur/maxLogEntries, 1),
 	}
 }
 
-func (fw *slidingWindowLog) FirstState() *limiter.State {
+func (sw *slidingWindowLog) FirstState() *limiter.State {
 	return &limiter.State{
-		Params: &LogParams{[]time.Time{}},
+		Params: &LogParams{Logs: []time.Time{}, Counts: []int{}},
 	}
 }
 
-func (sw *slidingWindowLog) Action(state *limiter.State) (bool, *limiter.State, error) {
+func (sw *slidingWindowLog) Action(state *limiter.State, cost int) (interfaces.Decision, *limiter.State, error) {
 	p, ok := state.Params.(*LogParams)
 	if !ok {
-		return false, nil, limiter.ErrInvalidState
+		return interfaces.Decision{}, nil, limiter.ErrInvalidState
 	}
+	p.normalize()
 
-	now := time.Now()
-	windowEnd := now.Add(-sw.windowDur)
+	now := sw.clock.Now()
+	windowStart := now.Add(-sw.windowDur)
 	ind := sort.Search(len(p.Logs), func(i int) bool {
-		return p.Logs[i].After(windowEnd)
+		return p.Logs[i].After(windowStart)
 	})
-
 	if ind > 0 {
-		copy(p.Logs, p.Logs[ind:])
-		p.Logs = p.Logs[:len(p.Logs)-ind]
+		p.Logs = append(p.Logs[:0], p.Logs[ind:]...)
+		p.Counts = append(p.Counts[:0], p.Counts[ind:]...)
 	}
 
-	allow := false
-	if len(p.Logs) < sw.limit {
-		p.Logs = append(p.Logs, now)
-		allow = true
+	count := 0
+	for _, c := range p.Counts {
+		count += c
+	}
+
+	res := limiter.LogResult{}
+	if count+cost <= sw.limit {
+		// cost = 0 - проверка без списания, журнал не меняется
+		last := len(p.Logs) - 1
+		switch {
+		case cost == 0:
+		case last >= 0 && p.Logs[last].Truncate(sw.resolution).Equal(now.Truncate(sw.resolution)):
+			p.Logs[last] = now
+			p.Counts[last] += cost
+		default:
+			p.Logs = append(p.Logs, now)
+			p.Counts = append(p.Counts, cost)
+		}
+		count += cost
+		res.Allowed = true
+	} else {
+		// самые старые записи, после истечения которых квоты хватит
+		freed := 0
+		for i, c := range p.Counts {
+			freed += c
+			if count-freed+cost <= sw.limit {
+				res.Release = p.Logs[i]
+				break
+			}
+		}
+	}
+
+	res.Count = count
+	if len(p.Logs) > 0 {
+		res.Newest = p.Logs[len(p.Logs)-1]
+	}
+	req := limiter.LogRequest{Now: now, Window: sw.windowDur, Limit: sw.limit, Cost: cost}
+	return sw.LogDecision(req, res), &limiter.State{Params: p}, nil
+}
+
+func (sw *slidingWindowLog) LogRequest(cost int) limiter.LogRequest {
+	return limiter.LogRequest{
+		Now:    sw.clock.Now(),
+		Window: sw.windowDur,
+		Limit:  sw.limit,
+		Cost:   cost,
+	}
+}
+
+func (sw *slidingWindowLog) LogDecision(req limiter.LogRequest, res limiter.LogResult) interfaces.Decision {
+	d := interfaces.Decision{
+		Allowed:   res.Allowed,
+		Limit:     int64(req.Limit),
+		Remaining: int64(max(req.Limit-res.Count, 0)),
+		Reset:     req.Now,
+	}
+	if !res.Newest.IsZero() {
+		d.Reset = res.Newest.Add(req.Window)
+	}
+	if !res.Allowed {
+		d.RetryAfter = d.Reset.Sub(req.Now)
+		if !res.Release.IsZero() {
+			d.RetryAfter = res.Release.Add(req.Window).Sub(req.Now)
+		}
 	}
-	return allow, &limiter.State{Params: p}, nil
+	return d
 }
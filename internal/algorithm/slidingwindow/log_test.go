@@ -0,0 +1,93 @@
+package slidingwindow
+
+import (
+	"gateway/internal/clock"
+	"testing"
+	"time"
+)
+
+// Журнал за минуту, лимит 3
+func TestSlidingWindowLogBoundaries(t *testing.T) {
+	type step struct {
+		advance    time.Duration
+		cost       int
+		allowed    bool
+		retryAfter time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "limit within window",
+			steps: []step{
+				{cost: 1, allowed: true},
+				{cost: 2, allowed: true},
+				{cost: 1, retryAfter: time.Minute},
+			},
+		},
+		{
+			name: "entry expires exactly at window edge",
+			steps: []step{
+				{cost: 3, allowed: true},
+				{advance: time.Minute - time.Millisecond, cost: 1, retryAfter: time.Millisecond},
+				{advance: time.Millisecond, cost: 3, allowed: true},
+			},
+		},
+		{
+			name: "oldest request releases quota first",
+			steps: []step{
+				{cost: 1, allowed: true},
+				{advance: 20 * time.Second, cost: 1, allowed: true},
+				{advance: 20 * time.Second, cost: 1, allowed: true},
+				{cost: 1, retryAfter: 20 * time.Second},
+				{advance: 20 * time.Second, cost: 1, allowed: true},
+				{cost: 1, retryAfter: 20 * time.Second},
+			},
+		},
+		{
+			name: "check without cost does not log",
+			steps: []step{
+				{cost: 0, allowed: true},
+				{cost: 0, allowed: true},
+				{cost: 3, allowed: true},
+				{cost: 0, allowed: true},
+				{cost: 1, retryAfter: time.Minute},
+			},
+		},
+		{
+			// запросы из одного интервала window/1024 хранятся одной записью
+			// со временем последнего из них
+			name: "close requests expire with the later one",
+			steps: []step{
+				{cost: 2, allowed: true},
+				{advance: time.Millisecond, cost: 1, allowed: true},
+				{cost: 1, retryAfter: time.Minute},
+				{advance: time.Minute - time.Millisecond, cost: 1, retryAfter: time.Millisecond},
+				{advance: time.Millisecond, cost: 3, allowed: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+			sw := NewSlidingWindowLog(3, time.Minute, clk)
+
+			state := sw.FirstState()
+			for i, s := range tt.steps {
+				clk.Advance(s.advance)
+				d, next, err := sw.Action(state, s.cost)
+				if err != nil {
+					t.Fatalf("step %d: Action() error = %v", i, err)
+				}
+				if d.Allowed != s.allowed || d.RetryAfter != s.retryAfter {
+					t.Errorf("step %d: allowed = %v, retryAfter = %v, want %v, %v",
+						i, d.Allowed, d.RetryAfter, s.allowed, s.retryAfter)
+				}
+				state = next
+			}
+		})
+	}
+}
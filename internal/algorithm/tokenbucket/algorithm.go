@@ -1,8 +1,10 @@
 package tokenbucket
 
 import (
-	"encoding/json"
+	"gateway/internal/algorithm"
 	"gateway/internal/limiter"
+	"gateway/server/interfaces"
+	"math"
 	"time"
 )
 
@@ -11,31 +13,41 @@ type Params struct {
 	LastUpdate time.Time
 }
 
-func (s *Params) Marshal() ([]byte, error) { return json.Marshal(s) }
+func (s *Params) Marshal() ([]byte, error) {
+	return algorithm.NewEncoder().Float(s.Tokens).Time(s.LastUpdate).Bytes(), nil
+}
+
+func (s *Params) UnmarshalBinary(data []byte) error {
+	d := algorithm.NewDecoder(data)
+	s.Tokens, s.LastUpdate = d.Float(), d.Time()
+	return d.Err()
+}
 
 type tokenBucket struct {
 	capacity int
 	rate     float64
+	clock    limiter.Clock
 }
 
-func NewTokenBucket(capacity int, rate float64) *tokenBucket {
+func NewTokenBucket(capacity int, rate float64, clock limiter.Clock) *tokenBucket {
 	return &tokenBucket{
+		clock:    clock,
 		capacity: capacity,
 		rate:     rate,
 	}
 }
 
 func (tb *tokenBucket) FirstState() *limiter.State {
-	return &limiter.State{Params: &Params{0, time.Now()}}
+	return &limiter.State{Params: &Params{0, tb.clock.Now()}}
 }
 
-func (tb *tokenBucket) Action(state *limiter.State) (bool, *limiter.State, error) {
+func (tb *tokenBucket) Action(state *limiter.State, cost int) (interfaces.Decision, *limiter.State, error) {
 	p, ok := state.Params.(*Params)
 	if !ok {
-		return false, nil, limiter.ErrInvalidState
+		return interfaces.Decision{}, nil, limiter.ErrInvalidState
 	}
 
-	now := time.Now()
+	now := tb.clock.Now()
 	elapsed := now.Sub(p.LastUpdate).Seconds()
 
 	p.Tokens += elapsed * tb.rate
@@ -45,10 +57,41 @@ func (tb *tokenBucket) Action(state *limiter.State) (bool, *limiter.State, error
 	p.LastUpdate = now
 
 	allow := false
-	if p.Tokens >= 1 {
-		p.Tokens -= 1
+	if p.Tokens >= float64(cost) {
+		p.Tokens -= float64(cost)
 		allow = true
 	}
 
-	return allow, &limiter.State{Params: p}, nil
+	return tb.decision(allow, p, now, cost), &limiter.State{Params: p}, nil
+}
+
+// Токены возвращаются в ведро, но не сверх capacity
+func (tb *tokenBucket) Refund(state *limiter.State, cost int, _ interfaces.Decision) (*limiter.State, error) {
+	p, ok := state.Params.(*Params)
+	if !ok {
+		return nil, limiter.ErrInvalidState
+	}
+	p.Tokens = min(p.Tokens+float64(cost), float64(tb.capacity))
+	return &limiter.State{Params: p}, nil
+}
+
+func (tb *tokenBucket) decision(allow bool, p *Params, now time.Time, cost int) interfaces.Decision {
+	d := interfaces.Decision{
+		Allowed:   allow,
+		Limit:     int64(tb.capacity),
+		Remaining: int64(math.Floor(p.Tokens)),
+		Reset:     now.Add(tb.refillTime(float64(tb.capacity) - p.Tokens)),
+	}
+	if !allow {
+		d.RetryAfter = tb.refillTime(float64(cost) - p.Tokens)
+	}
+	return d
+}
+
+// Ведро без пополнения не наполнится никогда: время не сообщается
+func (tb *tokenBucket) refillTime(tokens float64) time.Duration {
+	if tb.rate <= 0 || tokens <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / tb.rate * float64(time.Second))
 }
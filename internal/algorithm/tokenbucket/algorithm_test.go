@@ -0,0 +1,99 @@
+package tokenbucket
+
+import (
+	"gateway/internal/clock"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type step struct {
+	advance    time.Duration
+	cost       int
+	allowed    bool
+	retryAfter time.Duration
+}
+
+// Ведро на 2 токена, 1 токен в секунду
+func TestTokenBucketBoundaries(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "bucket starts empty",
+			steps: []step{
+				{cost: 1, retryAfter: time.Second},
+				{advance: time.Second - time.Millisecond, cost: 1, retryAfter: time.Millisecond},
+				{advance: time.Millisecond, cost: 1, allowed: true},
+			},
+		},
+		{
+			name: "refill is capped by capacity",
+			steps: []step{
+				{advance: 10 * time.Second, cost: 1, allowed: true},
+				{cost: 1, allowed: true},
+				{cost: 1, retryAfter: time.Second},
+			},
+		},
+		{
+			name: "partial token waits for the rest",
+			steps: []step{
+				{advance: 1500 * time.Millisecond, cost: 1, allowed: true},
+				{cost: 1, retryAfter: 500 * time.Millisecond},
+				{advance: 500 * time.Millisecond, cost: 1, allowed: true},
+			},
+		},
+		{
+			name: "cost waits for several tokens",
+			steps: []step{
+				{advance: time.Second, cost: 2, retryAfter: time.Second},
+				{advance: time.Second, cost: 2, allowed: true},
+			},
+		},
+		{
+			name: "cost above capacity is never allowed",
+			steps: []step{
+				{advance: time.Minute, cost: 3, retryAfter: time.Second},
+				{advance: time.Minute, cost: 3, retryAfter: time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(start)
+			tb := NewTokenBucket(2, 1, clk)
+
+			state := tb.FirstState()
+			for i, s := range tt.steps {
+				clk.Advance(s.advance)
+				d, next, err := tb.Action(state, s.cost)
+				if err != nil {
+					t.Fatalf("step %d: Action() error = %v", i, err)
+				}
+				if d.Allowed != s.allowed || d.RetryAfter != s.retryAfter {
+					t.Errorf("step %d: allowed = %v, retryAfter = %v, want %v, %v",
+						i, d.Allowed, d.RetryAfter, s.allowed, s.retryAfter)
+				}
+				state = next
+			}
+		})
+	}
+}
+
+// Без пополнения время до сброса не переполняет Duration
+func TestTokenBucketZeroRate(t *testing.T) {
+	clk := clock.NewFake(start)
+	tb := NewTokenBucket(2, 0, clk)
+
+	d, _, err := tb.Action(tb.FirstState(), 1)
+	if err != nil {
+		t.Fatalf("Action() error = %v", err)
+	}
+	if d.Allowed || d.RetryAfter != 0 || !d.Reset.Equal(start) {
+		t.Errorf("allowed = %v, retryAfter = %v, reset = %v, want denied with no wait at %v",
+			d.Allowed, d.RetryAfter, d.Reset, start)
+	}
+}
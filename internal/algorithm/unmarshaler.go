@@ -1,19 +1,36 @@
 package algorithm
 
 import (
+	"encoding"
 	"encoding/json"
+	"fmt"
 	"gateway/internal/limiter"
 )
 
-type stateUnmarshaler[T limiter.Marshaler] struct{}
+type params[P any] interface {
+	*P
+	limiter.Marshaler
+	encoding.BinaryUnmarshaler
+}
+
+type stateUnmarshaler[P any, T params[P]] struct{}
 
-func NewStateUnmarshaler[T limiter.Marshaler]() *stateUnmarshaler[T] {
-	return &stateUnmarshaler[T]{}
+// Читает бинарное состояние, а также состояние в JSON,
+// записанное до перехода на бинарный формат
+func NewStateUnmarshaler[P any, T params[P]]() *stateUnmarshaler[P, T] {
+	return &stateUnmarshaler[P, T]{}
 }
 
-func (*stateUnmarshaler[T]) Unmarshal(data []byte) (*limiter.State, error) {
-	var p T
-	if err := json.Unmarshal(data, &p); err != nil {
+func (*stateUnmarshaler[P, T]) Unmarshal(data []byte) (*limiter.State, error) {
+	p := T(new(P))
+	if isJSON(data) {
+		if err := json.Unmarshal(data, p); err != nil {
+			return nil, fmt.Errorf("%w: %w", limiter.ErrInvalidState, err)
+		}
+		return &limiter.State{Params: p}, nil
+	}
+
+	if err := p.UnmarshalBinary(data); err != nil {
 		return nil, err
 	}
 	return &limiter.State{Params: p}, nil
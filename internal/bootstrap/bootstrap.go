@@ -10,6 +10,7 @@ import (
 
 	"gateway/server/handlers"
 	mw "gateway/server/middlewares"
+	"gateway/server/urlutils"
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -18,41 +19,69 @@ import (
 const (
 	metricsPath = "/metrics"
 	healthPath  = "/health"
+	adminPath   = "/admin"
 
 	defaultIsGlobalLimiter = false
 	defaultKeyTTL          = 0
-	defaultLogLevel        = config.LevelError
-	defaultServerTimiout   = time.Second * 10
+	defaultStorageBackend  = config.RedisStorageBackend
+	defaultCleanupInterval = time.Minute
+	defaultStorageMode     = config.LockStorageMode
+	defaultMaxRetries      = 10
+	defaultFailPolicy      = config.OpenFailPolicy
+	defaultLimiterMode     = config.EnforceLimiterMode
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 10 * time.Second
+
+	defaultAdaptiveController   = config.GradientController
+	defaultAdaptiveInitialLimit = 20
+	defaultAdaptiveMinLimit     = 1
+	defaultAdaptiveMaxLimit     = 1000
+	defaultAdaptiveBackoff      = 0.9
+	defaultAdaptiveSmoothing    = 0.2
+	defaultHybridBatch          = 10
+	defaultHybridSyncInterval   = time.Second
+	defaultLogLevel             = config.LevelError
+	defaultServerTimiout        = time.Second * 10
 )
 
 type Shutdown func(context.Context)
 
-func Run(fileConf config.FileConfig, envConf config.EnvConfig) Shutdown {
+// configPath нужен, чтобы перечитывать правила для клиентов без перезапуска
+func Run(configPath string, fileConf config.FileConfig, envConf config.EnvConfig) Shutdown {
 	setConfigDeafultValues(&fileConf, &envConf)
 
-	err := checkProxyRoutes(fileConf.Proxy.Router, metricsPath, healthPath)
+	err := checkProxyRoutes(fileConf.Proxy.Router, metricsPath, healthPath, adminPath)
 	if err != nil {
 		panic(err)
 	}
 
 	rootLogger := provideRootLogger(*envConf.LogLevel)
-	gateway, err := provideGateway(fileConf, envConf, rootLogger)
+	gateway, err := provideGateway(configPath, fileConf, envConf, rootLogger)
 	if err != nil {
 		panic(fmt.Errorf("cannot create gateway: %w", err))
 	}
 
+	trustedProxies, err := parseCIDRs(fileConf.TrustedProxies)
+	if err != nil {
+		panic(fmt.Errorf("invalid trusted_proxies: %w", err))
+	}
+	clientIPMw := mw.NewClientIP(urlutils.NewClientIPResolver(trustedProxies))
 	recoverMw := mw.NewRecover(rootLogger)
 	whitelistMw := mw.NewWhitelist(fileConf.Metrics.Hosts...)
 	metricHandler := whitelistMw.Wrap(promhttp.Handler())
 
 	opts := server.ServerOptions{
 		Gateway:     gateway,
-		Middlewares: []interfaces.Middleware{recoverMw},
+		Middlewares: []interfaces.Middleware{clientIPMw, recoverMw},
 		Handlers: map[string]http.Handler{
 			healthPath:  handlers.Health(),
 			metricsPath: metricHandler,
 		},
 	}
+	if len(fileConf.Admin.Hosts) > 0 {
+		adminWhitelistMw := mw.NewWhitelist(fileConf.Admin.Hosts...)
+		opts.Handlers[adminPath+"/"] = adminWhitelistMw.Wrap(handlers.Admin(adminPath, gateway.Limiters))
+	}
 	srv := server.NewServer(envConf.ServerConfig, opts)
 
 	go func() {
@@ -68,6 +97,7 @@ func Run(fileConf config.FileConfig, envConf config.EnvConfig) Shutdown {
 		if err := srv.Shutdown(ctx); err != nil {
 			fmt.Printf("shutdown error: %v", err)
 		}
+		gateway.Close()
 	}
 }
 
@@ -77,14 +107,18 @@ func setConfigDeafultValues(fileConf *config.FileConfig, envConf *config.EnvConf
 		fileConf.EdgeLimiter.IsGlobal = &v
 	}
 
-	keyTTL := &config.StorageSettings{KeyTTL: defaultKeyTTL}
+	setStorageDefaultValues(&fileConf.EdgeLimiter.Limiter, nil)
+	setShadowDefaultValues(&fileConf.EdgeLimiter.Limiter)
 
-	if fileConf.EdgeLimiter.Limiter.Storage == nil {
-		fileConf.EdgeLimiter.Limiter.Storage = keyTTL
+	var proxyStorage *config.StorageSettings
+	if fileConf.Proxy.Limiter != nil {
+		setStorageDefaultValues(fileConf.Proxy.Limiter, nil)
+		setShadowDefaultValues(fileConf.Proxy.Limiter)
+		proxyStorage = fileConf.Proxy.Limiter.Storage
 	}
-
-	if fileConf.Proxy.Limiter != nil && fileConf.Proxy.Limiter.Storage == nil {
-		fileConf.Proxy.Limiter.Storage = keyTTL
+	for _, lim := range routerLimiters(fileConf.Proxy.Router) {
+		setStorageDefaultValues(lim, proxyStorage)
+		setShadowDefaultValues(lim)
 	}
 
 	if envConf.LogLevel == nil {
@@ -102,3 +136,85 @@ func setConfigDeafultValues(fileConf *config.FileConfig, envConf *config.EnvConf
 		envConf.ServerConfig.WriteTimeout = &v
 	}
 }
+
+func setAdaptiveDefaultValues(cfg *config.AdaptiveSettings) {
+	if cfg.Controller == "" {
+		cfg.Controller = defaultAdaptiveController
+	}
+	if cfg.InitialLimit == 0 {
+		cfg.InitialLimit = defaultAdaptiveInitialLimit
+	}
+	if cfg.MinLimit == 0 {
+		cfg.MinLimit = defaultAdaptiveMinLimit
+	}
+	if cfg.MaxLimit == 0 {
+		cfg.MaxLimit = defaultAdaptiveMaxLimit
+	}
+	if cfg.Backoff == 0 {
+		cfg.Backoff = defaultAdaptiveBackoff
+	}
+	if cfg.Smoothing == 0 {
+		cfg.Smoothing = defaultAdaptiveSmoothing
+	}
+}
+
+func setHybridDefaultValues(cfg *config.HybridSettings) {
+	if cfg.Batch == 0 {
+		cfg.Batch = defaultHybridBatch
+	}
+	if cfg.SyncInterval == 0 {
+		cfg.SyncInterval = defaultHybridSyncInterval
+	}
+}
+
+// Теневые лимитеры без своих настроек используют хранилище, ключ и стоимость запросов lim
+func setShadowDefaultValues(lim *config.LimiterSettings) {
+	if lim.Mode == "" {
+		lim.Mode = defaultLimiterMode
+	}
+
+	for i := range lim.Shadow {
+		shadow := &lim.Shadow[i]
+		shadow.Mode = config.ShadowLimiterMode
+		if shadow.Key == "" {
+			shadow.Key = lim.Key
+		}
+		if shadow.Costs == nil {
+			shadow.Costs = lim.Costs
+		}
+		setStorageDefaultValues(shadow, lim.Storage)
+	}
+}
+
+// Если хранилище не задано, используется parent (может быть nil) или хранилище по умолчанию
+func setStorageDefaultValues(lim *config.LimiterSettings, parent *config.StorageSettings) {
+	if lim.OnError == "" {
+		lim.OnError = defaultFailPolicy
+	}
+
+	if lim.Storage == nil && parent != nil {
+		lim.Storage = parent
+		return
+	}
+	if lim.Storage == nil {
+		lim.Storage = &config.StorageSettings{KeyTTL: defaultKeyTTL}
+	}
+	if lim.Storage.Backend == "" {
+		lim.Storage.Backend = defaultStorageBackend
+	}
+	if lim.Storage.CleanupInterval == 0 {
+		lim.Storage.CleanupInterval = defaultCleanupInterval
+	}
+	if lim.Storage.Mode == "" {
+		lim.Storage.Mode = defaultStorageMode
+	}
+	if lim.Storage.MaxRetries == 0 {
+		lim.Storage.MaxRetries = defaultMaxRetries
+	}
+	if lim.Storage.BreakerFailures == 0 {
+		lim.Storage.BreakerFailures = defaultBreakerFailures
+	}
+	if lim.Storage.BreakerCooldown == 0 {
+		lim.Storage.BreakerCooldown = defaultBreakerCooldown
+	}
+}
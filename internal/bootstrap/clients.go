@@ -0,0 +1,260 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"gateway/config"
+	"gateway/pkg/datastructs"
+	"gateway/server"
+	"gateway/server/interfaces"
+	serverlimiter "gateway/server/limiter"
+	"net/netip"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Лимитер правила вместе с настройками, по которым он создан
+type builtClientRule struct {
+	settings config.LimiterSettings
+	limiter  interfaces.Limiter
+	closers  []func()
+}
+
+// Набор правил, подключенный к лимитеру
+type clientTiersBinding struct {
+	set    string
+	tiers  *serverlimiter.ClientTiers
+	rdb    *redis.Client
+	parent config.LimiterSettings
+	// при перечитывании лимитеры неизменившихся правил остаются прежними вместе с состоянием
+	built map[string]builtClientRule
+}
+
+// Правила для клиентов из секции clients. Файл конфигурации проверяется
+// раз в clientsReloadInterval, при изменении правила всех лимитеров заменяются.
+// Остальные настройки при этом не перечитываются
+type clientTiersProvider struct {
+	path string
+	log  interfaces.Logger
+
+	mu       sync.Mutex
+	sets     map[string][]config.ClientTierSettings
+	bindings []*clientTiersBinding
+	modTime  time.Time
+}
+
+func newClientTiersProvider(
+	path string, sets map[string][]config.ClientTierSettings, log interfaces.Logger,
+) *clientTiersProvider {
+	p := &clientTiersProvider{path: path, sets: sets, log: log}
+	if info, err := os.Stat(path); err == nil {
+		p.modTime = info.ModTime()
+	}
+	return p
+}
+
+// Правила для лимитера lim, nil - у него нет правил
+func (p *clientTiersProvider) provide(lim config.LimiterSettings, rdb *redis.Client) (*serverlimiter.ClientTiers, error) {
+	if lim.Clients == "" {
+		return nil, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	set, ok := p.sets[lim.Clients]
+	if !ok {
+		return nil, fmt.Errorf("client rules %q not found", lim.Clients)
+	}
+
+	b := &clientTiersBinding{set: lim.Clients, rdb: rdb, parent: lim}
+	rules, built, err := b.build(set)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create client rules %s: %w", lim.Clients, err)
+	}
+	b.tiers, b.built = serverlimiter.NewClientTiers(rules), built
+
+	p.bindings = append(p.bindings, b)
+	return b.tiers, nil
+}
+
+func (p *clientTiersProvider) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := p.reload(); err != nil {
+			p.log.Error(context.Background(), "cannot reload client rules", map[string]any{"error": err})
+		}
+	}
+}
+
+// Правила заменяются, только если их удалось создать для всех лимитеров
+func (p *clientTiersProvider) reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	if !info.ModTime().After(p.modTime) {
+		return nil
+	}
+	// ошибочная версия файла не перечитывается, пока ее не исправят
+	p.modTime = info.ModTime()
+
+	fileConf, err := config.LoadFileConfig(p.path)
+	if err != nil {
+		return err
+	}
+
+	rules := make([][]serverlimiter.ClientRule, len(p.bindings))
+	built := make([]map[string]builtClientRule, len(p.bindings))
+	for i, b := range p.bindings {
+		set, ok := fileConf.Clients[b.set]
+		if !ok {
+			err = fmt.Errorf("client rules %q not found", b.set)
+		} else if rules[i], built[i], err = b.build(set); err != nil {
+			err = fmt.Errorf("client rules %s: %w", b.set, err)
+		}
+		if err != nil {
+			// лимитеры, созданные для предыдущих наборов, не понадобятся
+			for j := range i {
+				releaseClientRules(built[j], p.bindings[j].built)
+			}
+			return err
+		}
+	}
+
+	for i, b := range p.bindings {
+		b.tiers.Store(rules[i])
+		// запросы, которые еще проверяются замененными лимитерами, завершатся:
+		// останавливается только их фоновая работа
+		releaseClientRules(b.built, built[i])
+		b.built = built[i]
+	}
+	p.sets = fileConf.Clients
+	p.log.Info(context.Background(), "client rules reloaded", map[string]any{"limiters": len(p.bindings)})
+	return nil
+}
+
+func (b *clientTiersBinding) build(cfgs []config.ClientTierSettings) (
+	_ []serverlimiter.ClientRule, _ map[string]builtClientRule, err error,
+) {
+	rules := make([]serverlimiter.ClientRule, 0, len(cfgs))
+	built := make(map[string]builtClientRule)
+	names := datastructs.NewSet[string]()
+	// лимитеры, созданные до ошибки, не понадобятся
+	defer func() {
+		if err != nil {
+			releaseClientRules(built, b.built)
+		}
+	}()
+
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			return nil, nil, fmt.Errorf("client rule name is required")
+		}
+		if names.Has(cfg.Name) {
+			return nil, nil, fmt.Errorf("duplicate client rule name: %s", cfg.Name)
+		}
+		names.Add(cfg.Name)
+
+		cidrs, err := parseCIDRs(cfg.CIDRs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("client rule %s: %w", cfg.Name, err)
+		}
+		rule := serverlimiter.ClientRule{
+			Name:   cfg.Name,
+			Keys:   cfg.Keys,
+			CIDRs:  cidrs,
+			Action: serverlimiter.ClientAction(cfg.Action),
+		}
+		if rule.Action == "" {
+			rule.Action = serverlimiter.ClientLimit
+		}
+
+		if rule.Action == serverlimiter.ClientLimit && cfg.Limiter != nil {
+			r, err := b.limiter(cfg.Name, *cfg.Limiter)
+			if err != nil {
+				return nil, nil, fmt.Errorf("client rule %s: %w", cfg.Name, err)
+			}
+			rule.Limiter, built[cfg.Name] = r.limiter, r
+		}
+		if err = rule.Validate(); err != nil {
+			return nil, nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, built, nil
+}
+
+// Лимитер правила с хранилищем и on_error лимитера, к которому подключены правила
+func (b *clientTiersBinding) limiter(name string, cfg config.LimiterSettings) (builtClientRule, error) {
+	if cfg.Key != "" || len(cfg.Costs) > 0 || len(cfg.Shadow) > 0 || cfg.Mode != "" || cfg.Clients != "" {
+		return builtClientRule{}, fmt.Errorf("key, costs, mode, shadow and clients are taken from the limiter")
+	}
+	if cfg.Subnet != nil || len(cfg.CountOnStatus) > 0 || len(cfg.RefundOnStatus) > 0 || cfg.Ban != nil ||
+		cfg.DenyCache != nil {
+		return builtClientRule{}, fmt.Errorf(
+			"subnet, count_on_status, refund_on_status, ban and deny_cache are not supported in client rule",
+		)
+	}
+	switch cfg.Type {
+	case config.ConcurrencyAlgorithm, config.AdaptiveAlgorithm, config.LeakyBucketAlgorithm:
+		return builtClientRule{}, fmt.Errorf("%s algorithm cannot be used in client rule", cfg.Type)
+	}
+
+	if cfg.OnError == "" {
+		cfg.OnError = b.parent.OnError
+	}
+	setStorageDefaultValues(&cfg, b.parent.Storage)
+	setShadowDefaultValues(&cfg)
+	if cfg.Hybrid != nil {
+		setHybridDefaultValues(cfg.Hybrid)
+	}
+
+	if prev, ok := b.built[name]; ok && reflect.DeepEqual(prev.settings, cfg) {
+		return prev, nil
+	}
+
+	var opts server.LimiterOptions
+	if err := provideLimiter("client:"+name, cfg, b.rdb, &opts); err != nil {
+		return builtClientRule{}, err
+	}
+	return builtClientRule{settings: cfg, limiter: opts.Limiter, closers: opts.Closers}, nil
+}
+
+// Останавливает лимитеры правил, которых нет среди keep
+func releaseClientRules(rules, keep map[string]builtClientRule) {
+	for name, r := range rules {
+		if k, ok := keep[name]; ok && k.limiter == r.limiter {
+			continue
+		}
+		for _, c := range r.closers {
+			c()
+		}
+	}
+}
+
+// Сети или отдельные адреса
+func parseCIDRs(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(v)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid cidr %q: %w", v, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
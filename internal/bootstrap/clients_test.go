@@ -0,0 +1,142 @@
+package bootstrap
+
+import (
+	"fmt"
+	"gateway/config"
+	"gateway/internal/logging"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const clientsConfig = `
+clients:
+  edge:
+    - name: premium
+      keys: ["header:X-Api-Key=pk_*"]
+      limiter:
+        type: fixed_window
+        algorithm: {limit: %d, window_duration: 1m}
+        hybrid: {batch: 10}
+`
+
+const internalClientsConfig = `
+  internal:
+    - name: service
+      cidrs: ["10.0.0.0/8"]
+      limiter:
+        type: fixed_window
+        algorithm: {limit: 100, window_duration: 1m}
+        hybrid: {batch: 10}
+`
+
+// Файл правил, у которого время изменения растет при каждой записи
+type clientsFile struct {
+	t       *testing.T
+	path    string
+	modTime time.Time
+}
+
+func newClientsFile(t *testing.T) *clientsFile {
+	return &clientsFile{t: t, path: filepath.Join(t.TempDir(), "config.yaml"), modTime: time.Now()}
+}
+
+func (f *clientsFile) write(limit int, withInternal bool) {
+	f.t.Helper()
+	data := fmt.Appendf(nil, clientsConfig, limit)
+	if withInternal {
+		data = append(data, internalClientsConfig...)
+	}
+	if err := os.WriteFile(f.path, data, 0o600); err != nil {
+		f.t.Fatal(err)
+	}
+	// время изменения растет, даже если файл записан в ту же секунду
+	f.modTime = f.modTime.Add(time.Second)
+	if err := os.Chtimes(f.path, f.modTime, f.modTime); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// Провайдер правил, к которому подключены лимитеры с наборами sets
+func newTestClientTiersProvider(t *testing.T, f *clientsFile, sets ...string) *clientTiersProvider {
+	t.Helper()
+	fileConf, err := config.LoadFileConfig(f.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logging.NewSlogAdapter(slog.New(slog.DiscardHandler))
+	p := newClientTiersProvider(f.path, fileConf.Clients, log)
+
+	for _, set := range sets {
+		parent := config.LimiterSettings{
+			Clients: set,
+			OnError: config.OpenFailPolicy,
+			Storage: &config.StorageSettings{
+				Backend:         config.MemoryStorageBackend,
+				KeyTTL:          time.Minute,
+				CleanupInterval: time.Minute,
+			},
+		}
+		if _, err = p.provide(parent, nil); err != nil {
+			t.Fatalf("provide() error = %v", err)
+		}
+	}
+	return p
+}
+
+// Остановленные горутины завершаются не сразу
+func waitGoroutines(t *testing.T, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := runtime.NumGoroutine(); got > want {
+		t.Errorf("goroutines = %d, want at most %d", got, want)
+	}
+}
+
+// Замененные при перечитывании лимитеры правил останавливают фоновые горутины
+func TestClientTiersReloadReleasesLimiters(t *testing.T) {
+	const reloads = 20
+
+	f := newClientsFile(t)
+	f.write(1, false)
+	p := newTestClientTiersProvider(t, f, "edge")
+
+	before := runtime.NumGoroutine()
+	for i := range reloads {
+		f.write(i+2, false)
+		if err := p.reload(); err != nil {
+			t.Fatalf("reload() error = %v", err)
+		}
+	}
+	waitGoroutines(t, before)
+}
+
+// Если в новой версии файла нет набора одного из лимитеров, правила не меняются,
+// а лимитеры, уже созданные для других наборов, останавливаются
+func TestClientTiersReloadMissingSetReleasesLimiters(t *testing.T) {
+	const reloads = 20
+
+	f := newClientsFile(t)
+	f.write(1, true)
+	p := newTestClientTiersProvider(t, f, "edge", "internal")
+	edge := p.bindings[0].built["premium"].limiter
+
+	before := runtime.NumGoroutine()
+	for i := range reloads {
+		f.write(i+2, false)
+		if err := p.reload(); err == nil {
+			t.Fatal("reload() error = nil, want missing set error")
+		}
+	}
+	waitGoroutines(t, before)
+
+	if got := p.bindings[0].built["premium"].limiter; got != edge {
+		t.Error("rules of edge limiter were replaced by failed reload")
+	}
+}
@@ -1,53 +1,90 @@
 package bootstrap
 
 import (
+	"context"
 	"fmt"
 	"gateway/config"
 	"gateway/internal/algorithm"
+	"gateway/internal/algorithm/adaptive"
+	"gateway/internal/algorithm/concurrency"
 	"gateway/internal/algorithm/fixedwindow"
+	"gateway/internal/algorithm/gcra"
+	"gateway/internal/algorithm/leakybucket"
 	"gateway/internal/algorithm/slidingwindow"
 	"gateway/internal/algorithm/tokenbucket"
+	"gateway/internal/clock"
 	"gateway/internal/limiter"
 	"gateway/internal/logging"
 	"gateway/internal/metrics"
 	"gateway/internal/storages"
+	"gateway/pkg/datastructs"
 	"gateway/server"
 	"gateway/server/cache"
 	"gateway/server/interfaces"
+	serverlimiter "gateway/server/limiter"
 	"log/slog"
 	"os"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 const (
-	proxyMetricName           = "proxy"
-	httpCacheMetricName       = "http_cache"
-	edgeLimiterMetricName     = "edge_limiter"
-	internalLimiterMetricName = "internal_limiter"
+	proxyMetricName             = "proxy"
+	httpCacheMetricName         = "http_cache"
+	edgeLimiterMetricName       = "edge_limiter"
+	internalLimiterMetricName   = "internal_limiter"
+	edgeShadowMetricName        = "edge_limiter_shadow"
+	internalShadowMetricName    = "internal_limiter_shadow"
+	edgeDegradedMetricName      = "edge_limiter_degraded"
+	internalDegradedMetricName  = "internal_limiter_degraded"
+	edgeQueueMetricName         = "edge_limiter_queued"
+	internalQueueMetricName     = "internal_limiter_queued"
+	internalLimitMetricName     = "internal_limiter_concurrency_limit"
+	edgeBanMetricName           = "edge_limiter_bans"
+	internalBanMetricName       = "internal_limiter_bans"
+	edgeDenyCacheMetricName     = "edge_limiter_deny_cache"
+	internalDenyCacheMetricName = "internal_limiter_deny_cache"
 
 	gatewayLoggerName         = "gateway"
 	cacheLoggerName           = "http_cache"
 	edgeLimiterLoggerName     = "edge_limiter"
 	internalLimiterLoggerName = "internal_limiter"
+	clientsLoggerName         = "client_rules"
+
+	redisClockSyncInterval = time.Minute
+	overridesSyncInterval  = 5 * time.Second
+	bansFlushInterval      = time.Second
+	clientsReloadInterval  = 10 * time.Second
+
+	edgeLimiterName = "edge"
 
 	redisEdgeLimiterDB     = "/0"
 	redisInternalLimiterDB = "/1"
 	redisCacheDB           = "/2"
 )
 
-func provideGateway(fileConf config.FileConfig, envConf config.EnvConfig, rootLogger *logging.SlogAdapter) (*server.Gateway, error) {
+// Часы Redis по клиентам: лимитеры с одним клиентом, в том числе пересоздаваемые
+// при перечитывании правил для клиентов, используют одну синхронизацию
+var redisClocks = struct {
+	sync.Mutex
+	byClient map[*redis.Client]limiter.Clock
+}{byClient: make(map[*redis.Client]limiter.Clock)}
+
+func provideGateway(
+	configPath string, fileConf config.FileConfig, envConf config.EnvConfig, rootLogger *logging.SlogAdapter,
+) (*server.Gateway, error) {
+	adminEnabled := len(fileConf.Admin.Hosts) > 0
+	clients := newClientTiersProvider(configPath, fileConf.Clients, rootLogger.Component(clientsLoggerName))
+
 	redisURL := fmt.Sprint(envConf.RedisURL, redisEdgeLimiterDB)
 	edgeLimiterRedis, err := provideRedisClient(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create redis client %s: %w", redisURL, err)
 	}
 
-	egdeLim, err := provideLimiter(fileConf.EdgeLimiter.Limiter, edgeLimiterRedis)
-	if err != nil {
-		return nil, fmt.Errorf("cannot create edge limiter %w", err)
-	}
-
 	redisURL = fmt.Sprint(envConf.RedisURL, redisCacheDB)
 	cacheRedis, err := provideRedisClient(redisURL)
 	if err != nil {
@@ -77,6 +114,18 @@ func provideGateway(fileConf config.FileConfig, envConf config.EnvConfig, rootLo
 		return nil, fmt.Errorf("cannot cache storage metric: %w", err)
 	}
 
+	redisURL = fmt.Sprint(envConf.RedisURL, redisInternalLimiterDB)
+	internalLimiterRedis, err := provideRedisClient(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create redis client %s: %w", redisURL, err)
+	}
+	internalLimiters := &internalLimiterProvider{
+		rdb:     internalLimiterRedis,
+		log:     rootLogger.Component(internalLimiterLoggerName),
+		admin:   adminEnabled,
+		clients: clients,
+	}
+
 	routerOpts := server.RouterOptions{
 		Settings: fileConf.Proxy.Router,
 		Proxy: server.ProxyOptions{
@@ -88,44 +137,209 @@ func provideGateway(fileConf config.FileConfig, envConf config.EnvConfig, rootLo
 			Log:    rootLogger.Component(cacheLoggerName),
 			Store:  provideCacheStorage[*cache.ResponseContent](cacheRedis),
 		},
+		Limiter: &server.InternalLimiterOptions{
+			Default: proxyConfig.Limiter,
+			Provide: internalLimiters.provide,
+		},
+	}
+	edgeDegradedMetric, err := provideDegradedMetric(edgeDegradedMetricName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create edge limiter degraded metric: %w", err)
 	}
+
+	edgeLimiter := fileConf.EdgeLimiter.Limiter
+	if len(edgeLimiter.RefundOnStatus) > 0 {
+		return nil, fmt.Errorf("refund_on_status is supported only by proxy limiters")
+	}
+
+	var edgeShadowMetric interfaces.ShadowMetric
+	if edgeLimiter.Mode == config.ShadowLimiterMode || len(edgeLimiter.Shadow) > 0 {
+		if edgeShadowMetric, err = provideShadowMetric(edgeShadowMetricName); err != nil {
+			return nil, fmt.Errorf("cannot create edge limiter shadow metric: %w", err)
+		}
+	}
+
+	var edgeDenyCacheMetric interfaces.DenyCacheMetric
+	if edgeLimiter.DenyCache != nil || slices.ContainsFunc(edgeLimiter.Shadow, hasDenyCache) {
+		if edgeDenyCacheMetric, err = provideDenyCacheMetric(edgeDenyCacheMetricName); err != nil {
+			return nil, fmt.Errorf("cannot create edge limiter deny cache metric: %w", err)
+		}
+	}
+
 	limOpts := server.LimiterOptions{
-		Log:     rootLogger.Component(edgeLimiterLoggerName),
-		Metric:  edgeLimMetric,
-		Limiter: egdeLim,
+		Log:            rootLogger.Component(edgeLimiterLoggerName),
+		Metric:         edgeLimMetric,
+		DegradedMetric: edgeDegradedMetric,
+		Shadow:         edgeLimiter.Mode == config.ShadowLimiterMode,
+		ShadowMetric:   edgeShadowMetric,
+
+		DenyCacheMetric: edgeDenyCacheMetric,
+	}
+	if adminEnabled {
+		limOpts.Name = edgeLimiterName
+	}
+	if edgeLimiter.Ban != nil {
+		if limOpts.BanMetric, err = provideBanMetric(edgeBanMetricName); err != nil {
+			return nil, fmt.Errorf("cannot create edge limiter ban metric: %w", err)
+		}
+	}
+	if err = provideLimiter(edgeLimiterName, edgeLimiter, edgeLimiterRedis, &limOpts); err != nil {
+		return nil, fmt.Errorf("cannot create edge limiter %w", err)
+	}
+	if limOpts.Clients, err = clients.provide(edgeLimiter, edgeLimiterRedis); err != nil {
+		return nil, fmt.Errorf("cannot create edge limiter %w", err)
+	}
+	if limOpts.Shaper != nil {
+		limOpts.QueueMetric, err = provideQueueMetric(edgeQueueMetricName)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create edge limiter queue metric: %w", err)
+		}
 	}
 
 	builder := server.NewGatewayBuilder().
+		Logger(rootLogger.Component(gatewayLoggerName)).
 		Router(routerOpts).
-		EdgeLimiter(limOpts, isGlobal).
-		Logger(rootLogger.Component(gatewayLoggerName))
+		EdgeLimiter(limOpts, isGlobal)
 
-	if proxyConfig.Limiter != nil {
-		redisURL = fmt.Sprint(envConf.RedisURL, redisInternalLimiterDB)
-		internalLimiterRedis, err := provideRedisClient(redisURL)
-		if err != nil {
-			return nil, fmt.Errorf("cannot create redis client %s: %w", redisURL, err)
+	for i, shadow := range edgeLimiter.Shadow {
+		shadowOpts := server.LimiterOptions{
+			Log:            limOpts.Log,
+			Metric:         edgeLimMetric,
+			DegradedMetric: edgeDegradedMetric,
+			Shadow:         true,
+			ShadowMetric:   edgeShadowMetric,
+
+			DenyCacheMetric: edgeDenyCacheMetric,
+		}
+		shadowName := fmt.Sprintf("%s:shadow%d", edgeLimiterName, i)
+		if adminEnabled {
+			shadowOpts.Name = shadowName
 		}
+		if err = provideLimiter(shadowName, shadow, edgeLimiterRedis, &shadowOpts); err != nil {
+			return nil, fmt.Errorf("cannot create edge shadow limiter %d: %w", i, err)
+		}
+		builder.EdgeShadowLimiter(shadowOpts, isGlobal, fmt.Sprintf("shadow%d", i))
+	}
 
-		internalLim, err := provideLimiter(fileConf.EdgeLimiter.Limiter, internalLimiterRedis)
-		if err != nil {
-			return nil, fmt.Errorf("cannot create internal limiter %w", err)
+	gateway, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fileConf.Clients) > 0 {
+		go clients.watch(clientsReloadInterval)
+	}
+	return gateway, nil
+}
+
+// Создает лимитеры политик proxy. Метрики общие для всех политик
+// и регистрируются при создании первого лимитера, которому они нужны
+type internalLimiterProvider struct {
+	rdb *redis.Client
+	log interfaces.Logger
+	// лимитеры доступны админскому API
+	admin   bool
+	clients *clientTiersProvider
+
+	metric         interfaces.LimiterMetric
+	queueMetric    interfaces.QueueMetric
+	limitMetric    interfaces.LimitMetric
+	degradedMetric interfaces.DegradedMetric
+	shadowMetric   interfaces.ShadowMetric
+	banMetric      interfaces.BanMetric
+
+	denyCacheMetric interfaces.DenyCacheMetric
+}
+
+func (p *internalLimiterProvider) provide(name string, cfg config.LimiterSettings) (server.LimiterOptions, error) {
+	var err error
+	if p.metric == nil {
+		if p.metric, err = provideInternalLimiterMetric(); err != nil {
+			return server.LimiterOptions{}, fmt.Errorf("cannot create internal limiter metric: %w", err)
 		}
+	}
+	opts := server.LimiterOptions{Log: p.log, Metric: p.metric}
+	if opts.Clients, err = p.clients.provide(cfg, p.rdb); err != nil {
+		return server.LimiterOptions{}, err
+	}
 
-		internalLimMetric, err := provideInternalLimiterMetric()
+	if cfg.Type == config.AdaptiveAlgorithm {
+		if cfg.Mode == config.ShadowLimiterMode {
+			return server.LimiterOptions{}, fmt.Errorf("%s algorithm does not support shadow mode", cfg.Type)
+		}
+		if cfg.Key != "" {
+			return server.LimiterOptions{}, fmt.Errorf("%s algorithm limits requests per upstream and does not support key", cfg.Type)
+		}
+		if len(cfg.Costs) > 0 {
+			return server.LimiterOptions{}, fmt.Errorf("%s algorithm does not support request costs", cfg.Type)
+		}
+		if len(cfg.CountOnStatus) > 0 || len(cfg.RefundOnStatus) > 0 || cfg.Subnet != nil || cfg.Ban != nil ||
+			cfg.DenyCache != nil {
+			return server.LimiterOptions{}, fmt.Errorf(
+				"%s algorithm does not support count_on_status, refund_on_status, subnet, ban and deny_cache", cfg.Type,
+			)
+		}
+		if p.limitMetric == nil {
+			if p.limitMetric, err = provideLimitMetric(internalLimitMetricName); err != nil {
+				return server.LimiterOptions{}, fmt.Errorf("cannot create internal limiter limit metric: %w", err)
+			}
+		}
+
+		adaptiveLim, err := provideAdaptiveLimiter(cfg, p.limitMetric)
 		if err != nil {
-			return nil, fmt.Errorf("cannot create internal limiter metric: %w", err)
-		}
-		builder = builder.InternalLimiter(
-			server.LimiterOptions{
-				Log:     rootLogger.Component(internalLimiterLoggerName),
-				Metric:  internalLimMetric,
-				Limiter: internalLim,
-			},
-		)
+			return server.LimiterOptions{}, err
+		}
+		opts.Concurrency, opts.Observer = adaptiveLim, adaptiveLim
+		return opts, nil
 	}
 
-	return builder.Build()
+	if p.degradedMetric == nil {
+		if p.degradedMetric, err = provideDegradedMetric(internalDegradedMetricName); err != nil {
+			return server.LimiterOptions{}, fmt.Errorf("cannot create internal limiter degraded metric: %w", err)
+		}
+	}
+	opts.DegradedMetric = p.degradedMetric
+
+	if cfg.Mode == config.ShadowLimiterMode {
+		if p.shadowMetric == nil {
+			if p.shadowMetric, err = provideShadowMetric(internalShadowMetricName); err != nil {
+				return server.LimiterOptions{}, fmt.Errorf("cannot create internal limiter shadow metric: %w", err)
+			}
+		}
+		opts.Shadow, opts.ShadowMetric = true, p.shadowMetric
+	}
+	if p.admin {
+		opts.Name = name
+	}
+	if cfg.Ban != nil {
+		if p.banMetric == nil {
+			if p.banMetric, err = provideBanMetric(internalBanMetricName); err != nil {
+				return server.LimiterOptions{}, fmt.Errorf("cannot create internal limiter ban metric: %w", err)
+			}
+		}
+		opts.BanMetric = p.banMetric
+	}
+	if cfg.DenyCache != nil {
+		if p.denyCacheMetric == nil {
+			if p.denyCacheMetric, err = provideDenyCacheMetric(internalDenyCacheMetricName); err != nil {
+				return server.LimiterOptions{}, fmt.Errorf("cannot create internal limiter deny cache metric: %w", err)
+			}
+		}
+		opts.DenyCacheMetric = p.denyCacheMetric
+	}
+
+	if err = provideLimiter(name, cfg, p.rdb, &opts); err != nil {
+		return server.LimiterOptions{}, err
+	}
+	if opts.Shaper != nil {
+		if p.queueMetric == nil {
+			if p.queueMetric, err = provideQueueMetric(internalQueueMetricName); err != nil {
+				return server.LimiterOptions{}, fmt.Errorf("cannot create internal limiter queue metric: %w", err)
+			}
+		}
+		opts.QueueMetric = p.queueMetric
+	}
+	return opts, nil
 }
 
 func provideProxyMetric() (interfaces.ProxyMetric, error) {
@@ -152,6 +366,54 @@ func provideInternalLimiterMetric() (interfaces.LimiterMetric, error) {
 	return limMetric, nil
 }
 
+func provideQueueMetric(name string) (interfaces.QueueMetric, error) {
+	queueMetric := metrics.NewQueueMetric(name)
+	if err := queueMetric.StartCount(); err != nil {
+		return nil, err
+	}
+	return queueMetric, nil
+}
+
+func provideShadowMetric(name string) (interfaces.ShadowMetric, error) {
+	shadowMetric := metrics.NewShadowMetric(name)
+	if err := shadowMetric.StartCount(); err != nil {
+		return nil, err
+	}
+	return shadowMetric, nil
+}
+
+func provideDegradedMetric(name string) (interfaces.DegradedMetric, error) {
+	degradedMetric := metrics.NewDegradedMetric(name)
+	if err := degradedMetric.StartCount(); err != nil {
+		return nil, err
+	}
+	return degradedMetric, nil
+}
+
+func provideBanMetric(name string) (interfaces.BanMetric, error) {
+	banMetric := metrics.NewBanMetric(name)
+	if err := banMetric.StartCount(); err != nil {
+		return nil, err
+	}
+	return banMetric, nil
+}
+
+func provideDenyCacheMetric(name string) (interfaces.DenyCacheMetric, error) {
+	denyCacheMetric := metrics.NewDenyCacheMetric(name)
+	if err := denyCacheMetric.StartCount(); err != nil {
+		return nil, err
+	}
+	return denyCacheMetric, nil
+}
+
+func provideLimitMetric(name string) (interfaces.LimitMetric, error) {
+	limitMetric := metrics.NewLimitMetric(name)
+	if err := limitMetric.Register(); err != nil {
+		return nil, err
+	}
+	return limitMetric, nil
+}
+
 func provideCacheMetric() (interfaces.CacheMetric, error) {
 	cacheMetric := metrics.NewCacheMetric(httpCacheMetricName)
 	if err := cacheMetric.StartCount(); err != nil {
@@ -164,17 +426,435 @@ func provideCacheStorage[T interfaces.CacheContent](rdb *redis.Client) interface
 	return storages.NewRedisCache[T](rdb)
 }
 
-func provideLimiter(cfg config.LimiterSettings, rdb *redis.Client) (interfaces.Limiter, error) {
-	fact, err := provideAlgorithmFacade(cfg.Type, cfg.Algorithm)
+// Если opts.Name задано, лимитер становится доступен админскому API под этим именем
+// name отделяет записи лимитера (например, блокировки) от записей других лимитеров
+func provideLimiter(name string, cfg config.LimiterSettings, rdb *redis.Client, opts *server.LimiterOptions) error {
+	if cfg.Type == config.AdaptiveAlgorithm {
+		return fmt.Errorf("%s algorithm is supported only by proxy limiter", cfg.Type)
+	}
+
+	if cfg.Key != "" {
+		key, err := serverlimiter.ParseKeyExtractor(cfg.Key)
+		if err != nil {
+			return fmt.Errorf("invalid limiter key: %w", err)
+		}
+		opts.Key = key
+	}
+
+	if len(cfg.Costs) > 0 {
+		if cfg.Type == config.ConcurrencyAlgorithm {
+			return fmt.Errorf("%s algorithm does not support request costs", cfg.Type)
+		}
+		cost, err := provideRequestCost(cfg.Costs)
+		if err != nil {
+			return err
+		}
+		opts.Cost = cost
+	}
+
+	switch cfg.Mode {
+	case config.EnforceLimiterMode, config.ShadowLimiterMode:
+	default:
+		return fmt.Errorf("unknown limiter mode: %s", cfg.Mode)
+	}
+
+	switch cfg.OnError {
+	case config.OpenFailPolicy, config.ClosedFailPolicy, config.LocalFailPolicy:
+		opts.FailPolicy = serverlimiter.FailPolicy(cfg.OnError)
+	default:
+		return fmt.Errorf("unknown on_error policy: %s", cfg.OnError)
+	}
+
+	stor, err := provideLimiterStorage(*cfg.Storage, rdb, cfg.OnError, opts.DegradedMetric)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if c, ok := stor.(interface{ Close() }); ok {
+		opts.Closers = append(opts.Closers, c.Close)
 	}
+	clk := provideClock(*cfg.Storage, rdb)
+	opts.Clock = clk
 
-	stor := storages.NewRedisStorage(rdb, cfg.Storage.KeyTTL)
-	return limiter.NewLimiter(fact, stor), nil
+	if err = provideRateLimiter(cfg, stor, clk, opts); err != nil {
+		return err
+	}
+	if err = provideDenyCache(cfg, opts); err != nil {
+		return err
+	}
+	if err = provideSubnetLimiter(cfg.Subnet, stor, clk, opts); err != nil {
+		return err
+	}
+	if err = provideCountOnStatus(cfg, opts); err != nil {
+		return err
+	}
+	bans, err := provideBans(name, cfg, stor, clk, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Name != "" {
+		if err = provideLimiterAdmin(stor, clk, bans, opts); err != nil {
+			return err
+		}
+	}
+	return provideRefundOnStatus(cfg, opts)
+}
+
+func provideRateLimiter(cfg config.LimiterSettings, stor limiter.Storage, clk limiter.Clock, opts *server.LimiterOptions) error {
+	var err error
+	if len(cfg.Tiers) > 0 {
+		opts.Limiter, err = provideTieredLimiter(cfg.Tiers, stor, clk)
+		if err != nil {
+			return err
+		}
+		return provideHybridLimiter(cfg, opts)
+	}
+
+	if cfg.Hybrid != nil {
+		switch cfg.Type {
+		case config.ConcurrencyAlgorithm, config.LeakyBucketAlgorithm:
+			return fmt.Errorf("%s algorithm does not support hybrid mode", cfg.Type)
+		}
+	}
+
+	if cfg.Type == config.ConcurrencyAlgorithm {
+		algConf := cfg.Algorithm.(*config.ConcurrencySettings)
+		opts.Concurrency = limiter.NewConcurrencyLimiter(
+			string(cfg.Type),
+			concurrency.NewConcurrency(algConf.Limit, algConf.LeaseTTL, clk),
+			algorithm.NewStateUnmarshaler[concurrency.Params](),
+			stor,
+		)
+		return nil
+	}
+
+	fact, err := provideAlgorithmFacade(cfg.Type, cfg.Algorithm, clk)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Type == config.LeakyBucketAlgorithm {
+		shaper, err := limiter.NewShaper(fact, stor)
+		if err != nil {
+			return err
+		}
+		opts.Limiter, opts.Shaper = shaper, shaper
+		return nil
+	}
+	opts.Limiter = limiter.NewLimiter(fact, stor)
+	return provideHybridLimiter(cfg, opts)
+}
+
+// Квота подсети использует хранилище лимитера: ключи подсетей отделены префиксом
+func provideSubnetLimiter(cfg *config.SubnetSettings, stor limiter.Storage, clk limiter.Clock, opts *server.LimiterOptions) error {
+	if cfg == nil {
+		return nil
+	}
+	if opts.Limiter == nil || opts.Shaper != nil {
+		return fmt.Errorf("subnet limit requires a rate limiting algorithm")
+	}
+
+	sub := cfg.Limiter
+	if sub.Storage != nil || sub.Key != "" || len(sub.Costs) > 0 || len(sub.Shadow) > 0 ||
+		sub.Mode != "" || sub.OnError != "" || sub.Clients != "" || sub.Subnet != nil ||
+		len(sub.CountOnStatus) > 0 || len(sub.RefundOnStatus) > 0 || sub.Ban != nil ||
+		sub.DenyCache != nil {
+		return fmt.Errorf("subnet limiter supports only algorithm, tiers and hybrid")
+	}
+	switch sub.Type {
+	case config.ConcurrencyAlgorithm, config.AdaptiveAlgorithm, config.LeakyBucketAlgorithm:
+		return fmt.Errorf("%s algorithm cannot be used for subnet limit", sub.Type)
+	}
+
+	mask := serverlimiter.SubnetMask{IPv4: cfg.IPv4, IPv6: cfg.IPv6}
+	if mask.IPv4 == 0 {
+		mask.IPv4 = serverlimiter.DefaultSubnetMask.IPv4
+	}
+	if mask.IPv6 == 0 {
+		mask.IPv6 = serverlimiter.DefaultSubnetMask.IPv6
+	}
+	if err := mask.Validate(); err != nil {
+		return fmt.Errorf("invalid subnet: %w", err)
+	}
+
+	var subOpts server.LimiterOptions
+	if err := provideRateLimiter(sub, stor, clk, &subOpts); err != nil {
+		return fmt.Errorf("cannot create subnet limiter: %w", err)
+	}
+	opts.Subnet, opts.SubnetMask = subOpts.Limiter, mask
+	opts.Closers = append(opts.Closers, subOpts.Closers...)
+	return nil
+}
+
+// Проверка квоты до ответа идет без списания, гибридный лимитер
+// так проверить нельзя: он знает только об арендованной квоте
+func provideCountOnStatus(cfg config.LimiterSettings, opts *server.LimiterOptions) error {
+	if len(cfg.CountOnStatus) == 0 {
+		return nil
+	}
+	if opts.Limiter == nil || opts.Shaper != nil {
+		return fmt.Errorf("count_on_status requires a rate limiting algorithm")
+	}
+	if cfg.Hybrid != nil {
+		return fmt.Errorf("count_on_status does not support hybrid mode")
+	}
+
+	filter, err := serverlimiter.ParseStatusFilter(cfg.CountOnStatus)
+	if err != nil {
+		return fmt.Errorf("invalid count_on_status: %w", err)
+	}
+	opts.CountOnStatus = filter
+	return nil
+}
+
+// Кэш оборачивает лимитер до исключений админского API, поэтому ключ
+// с исключением кэш не отклоняет
+func provideDenyCache(cfg config.LimiterSettings, opts *server.LimiterOptions) error {
+	if cfg.DenyCache == nil {
+		return nil
+	}
+	switch {
+	case cfg.DenyCache.Size < 1:
+		return fmt.Errorf("deny_cache size must be positive")
+	case opts.Shaper != nil || opts.Concurrency != nil:
+		return fmt.Errorf("%s algorithm does not support deny_cache", cfg.Type)
+	case cfg.Hybrid != nil:
+		return fmt.Errorf("deny_cache is not supported in hybrid mode")
+	case len(cfg.CountOnStatus) > 0:
+		return fmt.Errorf("deny_cache is not compatible with count_on_status")
+	}
+	opts.Limiter = limiter.WithDenyCache(opts.Limiter, cfg.DenyCache.Size, opts.DenyCacheMetric)
+	return nil
+}
+
+func hasDenyCache(cfg config.LimiterSettings) bool {
+	return cfg.DenyCache != nil
+}
+
+// Блокировки хранятся в хранилище лимитера, nil - блокировки выключены
+func provideBans(
+	name string, cfg config.LimiterSettings, stor limiter.Storage, clk limiter.Clock, opts *server.LimiterOptions,
+) (*limiter.Bans, error) {
+	if cfg.Ban == nil {
+		return nil, nil
+	}
+	if cfg.Mode == config.ShadowLimiterMode {
+		return nil, fmt.Errorf("ban is not supported in shadow mode")
+	}
+	if cfg.Ban.Strikes < 1 || cfg.Ban.Window <= 0 || cfg.Ban.Duration <= 0 {
+		return nil, fmt.Errorf("ban strikes, window and duration must be positive")
+	}
+
+	banStor, ok := stor.(limiter.BanStorage)
+	if !ok {
+		return nil, fmt.Errorf("%s storage does not support ban", cfg.Storage.Backend)
+	}
+
+	metric, log := opts.BanMetric, opts.Log
+	onBan := func(key string, until time.Time) {
+		if metric != nil {
+			metric.Inc(key, serverlimiter.BanEvent)
+		}
+		log.Info(context.Background(), "key banned", map[string]any{"key": key, "until": until})
+	}
+	bans := limiter.NewBans(name, banStor, clk, limiter.BanPolicy{
+		Strikes:  cfg.Ban.Strikes,
+		Window:   cfg.Ban.Window,
+		Duration: cfg.Ban.Duration,
+	}, bansFlushInterval, onBan)
+	opts.Bans, opts.Closers = bans, append(opts.Closers, bans.Close)
+	return bans, nil
+}
+
+// Квоту возвращают только лимитеры, алгоритм которых это поддерживает
+func provideRefundOnStatus(cfg config.LimiterSettings, opts *server.LimiterOptions) error {
+	if len(cfg.RefundOnStatus) == 0 {
+		return nil
+	}
+	if cfg.Hybrid != nil {
+		return fmt.Errorf("refund_on_status does not support hybrid mode")
+	}
+	if _, ok := opts.Limiter.(interfaces.ReservingLimiter); !ok || opts.Shaper != nil {
+		return fmt.Errorf("refund_on_status is supported only by fixed_window, token_bucket and gcra algorithms")
+	}
+	if opts.CountOnStatus != nil || opts.Subnet != nil {
+		return fmt.Errorf("refund_on_status cannot be used with count_on_status and subnet")
+	}
+
+	filter, err := serverlimiter.ParseStatusFilter(cfg.RefundOnStatus)
+	if err != nil {
+		return fmt.Errorf("invalid refund_on_status: %w", err)
+	}
+	opts.RefundOnStatus = filter
+	return nil
+}
+
+// Исключения для ключей и просмотр состояния. Лимитер в opts
+// оборачивается, чтобы исключения действовали вместо его квоты
+func provideLimiterAdmin(stor limiter.Storage, clk limiter.Clock, bans *limiter.Bans, opts *server.LimiterOptions) error {
+	custom := func(limit int, window time.Duration) (limiter.Algorithm, limiter.Unmarshaler[limiter.State]) {
+		return fixedwindow.NewFixedWindow(limit, window, clk), algorithm.NewStateUnmarshaler[fixedwindow.Params]()
+	}
+
+	var lim any = opts.Limiter
+	if opts.Concurrency != nil {
+		lim, custom = opts.Concurrency, nil
+	}
+	overrides := limiter.NewOverrides(opts.Name, stor, custom, overridesSyncInterval)
+	opts.Closers = append(opts.Closers, overrides.Close)
+
+	admin, err := limiter.NewAdmin(lim, overrides, bans)
+	if err != nil {
+		return err
+	}
+	opts.Admin = admin
+
+	switch {
+	case opts.Concurrency != nil:
+		opts.Concurrency = limiter.ConcurrencyWithOverrides(opts.Concurrency, overrides)
+	case opts.Shaper != nil:
+		shaper := limiter.ShaperWithOverrides(opts.Shaper, overrides)
+		opts.Limiter, opts.Shaper = shaper, shaper
+	default:
+		opts.Limiter = limiter.WithOverrides(opts.Limiter, overrides)
+	}
+	return nil
 }
 
-func provideAlgorithmFacade(algType config.AlgorithmType, settings any) (*limiter.AlgorithmFacade, error) {
+// Оборачивает opts.Limiter в гибридный лимитер, если он включен
+func provideHybridLimiter(cfg config.LimiterSettings, opts *server.LimiterOptions) error {
+	if cfg.Hybrid == nil {
+		return nil
+	}
+	setHybridDefaultValues(cfg.Hybrid)
+	if cfg.Hybrid.Batch < 1 {
+		return fmt.Errorf("hybrid batch must be positive")
+	}
+
+	h := limiter.NewHybridLimiter(opts.Limiter, cfg.Hybrid.Batch, cfg.Hybrid.SyncInterval)
+	opts.Limiter, opts.Closers = h, append(opts.Closers, h.Close)
+	return nil
+}
+
+func provideRequestCost(cfgs []config.CostSettings) (serverlimiter.RequestCost, error) {
+	rules := make([]serverlimiter.CostRule, len(cfgs))
+	for i, cfg := range cfgs {
+		rules[i] = serverlimiter.CostRule{
+			Method:    cfg.Method,
+			Path:      cfg.Path,
+			Cost:      cfg.Cost,
+			BodyBytes: cfg.BodyBytes,
+		}
+	}
+	return serverlimiter.NewRequestCost(rules)
+}
+
+func provideTieredLimiter(cfgs []config.LimiterSettings, stor limiter.Storage, clk limiter.Clock) (interfaces.Limiter, error) {
+	tiers := make([]limiter.Tier, 0, len(cfgs))
+	names := datastructs.NewSet[string]()
+
+	for _, cfg := range cfgs {
+		switch cfg.Type {
+		case config.ConcurrencyAlgorithm, config.AdaptiveAlgorithm, config.LeakyBucketAlgorithm:
+			return nil, fmt.Errorf("%s algorithm cannot be used as a quota tier", cfg.Type)
+		}
+
+		name := cfg.Name
+		if name == "" {
+			name = string(cfg.Type)
+		}
+		if names.Has(name) {
+			return nil, fmt.Errorf("duplicate quota tier name: %s", name)
+		}
+		names.Add(name)
+
+		fact, err := provideAlgorithmFacade(cfg.Type, cfg.Algorithm, clk)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create quota tier %s: %w", name, err)
+		}
+		tiers = append(tiers, limiter.Tier{Name: name, Facade: fact})
+	}
+	return limiter.NewTieredLimiter(tiers, stor), nil
+}
+
+func provideAdaptiveLimiter(cfg config.LimiterSettings, limitMetric interfaces.LimitMetric) (interfaces.AdaptiveLimiter, error) {
+	algConf := cfg.Algorithm.(*config.AdaptiveSettings)
+	setAdaptiveDefaultValues(algConf)
+
+	var newController func() limiter.LimitController
+	switch algConf.Controller {
+	case config.AIMDController:
+		newController = func() limiter.LimitController {
+			return adaptive.NewAIMD(algConf.LatencyThreshold, algConf.Backoff)
+		}
+	case config.GradientController:
+		newController = func() limiter.LimitController {
+			return adaptive.NewGradient(algConf.Smoothing, algConf.Backoff)
+		}
+	default:
+		return nil, fmt.Errorf("unknown adaptive controller: %s", algConf.Controller)
+	}
+
+	return limiter.NewAdaptiveLimiter(
+		newController,
+		algConf.InitialLimit, algConf.MinLimit, algConf.MaxLimit,
+		limitMetric,
+	), nil
+}
+
+func provideLimiterStorage(
+	cfg config.StorageSettings,
+	rdb *redis.Client,
+	onError config.FailPolicy,
+	degradedMetric interfaces.DegradedMetric,
+) (limiter.Storage, error) {
+	switch cfg.Backend {
+	case config.RedisStorageBackend:
+	case config.MemoryStorageBackend:
+		return storages.NewMemoryStorage(cfg.KeyTTL, cfg.MaxMemory, cfg.CleanupInterval), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+	}
+
+	var stor limiter.Storage
+	switch cfg.Mode {
+	case config.LockStorageMode:
+		stor = storages.NewRedisStorage(rdb, cfg.KeyTTL)
+
+	case config.OptimisticStorageMode:
+		stor = storages.NewRedisStorage(
+			rdb, cfg.KeyTTL, storages.WithOptimisticLocking(cfg.MaxRetries),
+		)
+
+	default:
+		return nil, fmt.Errorf("unknown storage mode: %s", cfg.Mode)
+	}
+
+	var fallback limiter.Storage
+	if onError == config.LocalFailPolicy {
+		fallback = storages.NewMemoryStorage(cfg.KeyTTL, cfg.MaxMemory, cfg.CleanupInterval)
+	}
+	return storages.NewBreakerStorage(
+		stor, fallback, cfg.BreakerFailures, cfg.BreakerCooldown, degradedMetric,
+	), nil
+}
+
+// Состояние в Redis общее для экземпляров шлюза, поэтому время берется у Redis
+func provideClock(cfg config.StorageSettings, rdb *redis.Client) limiter.Clock {
+	if cfg.Backend != config.RedisStorageBackend {
+		return clock.Real()
+	}
+
+	redisClocks.Lock()
+	defer redisClocks.Unlock()
+	clk, ok := redisClocks.byClient[rdb]
+	if !ok {
+		clk = clock.NewRedisClock(rdb, redisClockSyncInterval)
+		redisClocks.byClient[rdb] = clk
+	}
+	return clk
+}
+
+func provideAlgorithmFacade(algType config.AlgorithmType, settings any, clk limiter.Clock) (*limiter.AlgorithmFacade, error) {
 	var (
 		alg     limiter.Algorithm
 		unmarsh limiter.Unmarshaler[limiter.State]
@@ -183,27 +863,42 @@ func provideAlgorithmFacade(algType config.AlgorithmType, settings any) (*limite
 	switch algType {
 	case config.TokenBucketAlgorithm:
 		algConf := settings.(*config.TokenBucketSettings)
-		alg = tokenbucket.NewTokenBucket(algConf.Capacity, algConf.Rate)
-		unmarsh = algorithm.NewStateUnmarshaler[*tokenbucket.Params]()
+		alg = tokenbucket.NewTokenBucket(algConf.Capacity, algConf.Rate, clk)
+		unmarsh = algorithm.NewStateUnmarshaler[tokenbucket.Params]()
 
 	case config.FixedWindowAlgorithm:
 		algConf := settings.(*config.FixedWindowSettings)
-		alg = fixedwindow.NewFixedWindow(algConf.Limit, algConf.WindowDuration)
-		unmarsh = algorithm.NewStateUnmarshaler[*fixedwindow.Params]()
+		alg = fixedwindow.NewFixedWindow(algConf.Limit, algConf.WindowDuration, clk)
+		unmarsh = algorithm.NewStateUnmarshaler[fixedwindow.Params]()
 
 	case config.SlidingWindowLogAlgorithm:
 		algConf := settings.(*config.SlidingWindowLogSettings)
-		alg = slidingwindow.NewSlidingWindowCounter(
-			algConf.WindowDuration, algConf.Limit, int64(algConf.Limit),
-		)
-		unmarsh = algorithm.NewStateUnmarshaler[*slidingwindow.LogParams]()
+		alg = slidingwindow.NewSlidingWindowLog(algConf.Limit, algConf.WindowDuration, clk)
+		unmarsh = algorithm.NewStateUnmarshaler[slidingwindow.LogParams]()
 
 	case config.SlidingWindowCounterAlgorithm:
 		algConf := settings.(*config.SlidingWindowCounterSettings)
 		alg = slidingwindow.NewSlidingWindowCounter(
-			algConf.WindowDuration, algConf.BucketsNum, algConf.Limit,
+			algConf.WindowDuration, algConf.BucketsNum, algConf.Limit, clk,
 		)
-		unmarsh = algorithm.NewStateUnmarshaler[*slidingwindow.CounterParams]()
+		unmarsh = algorithm.NewStateUnmarshaler[slidingwindow.CounterParams]()
+
+	case config.GCRAAlgorithm:
+		algConf := settings.(*config.GCRASettings)
+		if algConf.Limit < 1 || algConf.Period <= 0 {
+			return nil, fmt.Errorf("gcra limit and period must be positive")
+		}
+		// интервал между запросами period/limit не должен округлиться до нуля
+		if algConf.Period < time.Duration(algConf.Limit) {
+			return nil, fmt.Errorf("gcra period %s is too short for limit %d", algConf.Period, algConf.Limit)
+		}
+		alg = gcra.NewGCRA(algConf.Limit, algConf.Period, algConf.Burst, clk)
+		unmarsh = algorithm.NewStateUnmarshaler[gcra.Params]()
+
+	case config.LeakyBucketAlgorithm:
+		algConf := settings.(*config.LeakyBucketSettings)
+		alg = leakybucket.NewLeakyBucket(algConf.Rate, algConf.MaxDelay, clk)
+		unmarsh = algorithm.NewStateUnmarshaler[leakybucket.Params]()
 
 	}
 
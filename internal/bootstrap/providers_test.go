@@ -0,0 +1,24 @@
+package bootstrap
+
+import (
+	"gateway/config"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Лимитеры с одним клиентом Redis используют одни часы
+func TestProvideClockSharedPerClient(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	t.Cleanup(func() { rdb.Close() })
+	other := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	t.Cleanup(func() { other.Close() })
+
+	cfg := config.StorageSettings{Backend: config.RedisStorageBackend}
+	if provideClock(cfg, rdb) != provideClock(cfg, rdb) {
+		t.Error("clocks of one client differ")
+	}
+	if provideClock(cfg, rdb) == provideClock(cfg, other) {
+		t.Error("clients share a clock")
+	}
+}
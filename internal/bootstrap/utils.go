@@ -23,3 +23,30 @@ func checkProxyRoutes(cfg config.RouterSettings, reserved ...string) error {
 
 	return nil
 }
+
+// Все блоки limiter в настройках маршрутизации, кроме proxy.limiter
+func routerLimiters(cfg config.RouterSettings) []*config.LimiterSettings {
+	var limiters []*config.LimiterSettings
+	add := func(lim *config.LimiterSettings) {
+		if lim != nil {
+			limiters = append(limiters, lim)
+		}
+	}
+
+	for _, alias := range cfg.UpstreamsAliases {
+		add(alias.Limiter)
+	}
+	if cfg.Default != nil {
+		add(cfg.Default.Limiter)
+	}
+	for _, route := range cfg.Routes {
+		add(route.Limiter)
+		if route.Default != nil {
+			add(route.Default.Limiter)
+		}
+		for _, path := range route.Paths {
+			add(path.Limiter)
+		}
+	}
+	return limiters
+}
@@ -0,0 +1,41 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type realClock struct{}
+
+// Локальные часы процесса
+func Real() realClock { return realClock{} }
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// Часы, которые идут только по команде
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Fake) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+func (c *Fake) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
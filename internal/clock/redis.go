@@ -0,0 +1,57 @@
+package clock
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// сколько ждать ответа на TIME
+const syncTimeout = time.Second
+
+type redisClock struct {
+	rdb *redis.Client
+	// разница между временем Redis и локальным временем
+	offset atomic.Int64
+}
+
+// Время сервера Redis: локальное время со смещением, которое раз в syncInterval
+// пересчитывается по команде TIME. Так экземпляры шлюза с общим Redis
+// используют одни часы, даже если их локальные часы расходятся.
+// До первой синхронизации и при недоступном Redis используется
+// последнее известное смещение (изначально 0)
+func NewRedisClock(rdb *redis.Client, syncInterval time.Duration) *redisClock {
+	c := &redisClock{rdb: rdb}
+	go func() {
+		ticker := time.NewTicker(syncInterval)
+		defer ticker.Stop()
+
+		for {
+			c.sync()
+			<-ticker.C
+		}
+	}()
+	return c
+}
+
+func (c *redisClock) Now() time.Time {
+	return time.Now().Add(time.Duration(c.offset.Load()))
+}
+
+func (c *redisClock) sync() {
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+
+	before := time.Now()
+	serverTime, err := c.rdb.Time(ctx).Result()
+	if err != nil {
+		return
+	}
+	rtt := time.Since(before)
+
+	// ответ сформирован примерно в середине запроса
+	local := before.Add(rtt / 2)
+	c.offset.Store(int64(serverTime.Sub(local)))
+}
@@ -0,0 +1,101 @@
+package limiter
+
+import (
+	"context"
+	"gateway/server/interfaces"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type adaptiveState struct {
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	ctrl     LimitController
+}
+
+// Ограничение числа одновременных запросов к сервису с лимитом,
+// который подстраивается по задержке и ошибкам его ответов.
+// Состояние хранится в памяти процесса
+type adaptiveLimiter struct {
+	mu     sync.Mutex
+	states map[string]*adaptiveState
+
+	newController func() LimitController
+	initial       float64
+	min, max      float64
+
+	// может быть nil
+	metric interfaces.LimitMetric
+}
+
+func NewAdaptiveLimiter(
+	newController func() LimitController,
+	initial, min, max int,
+	metric interfaces.LimitMetric,
+) *adaptiveLimiter {
+	return &adaptiveLimiter{
+		states:        make(map[string]*adaptiveState),
+		newController: newController,
+		initial:       float64(initial),
+		min:           float64(min),
+		max:           float64(max),
+		metric:        metric,
+	}
+}
+
+func (l *adaptiveLimiter) Acquire(ctx context.Context, key string) (func() error, bool, error) {
+	st := l.state(key)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.inflight >= int(math.Floor(st.limit)) {
+		return nil, false, nil
+	}
+	st.inflight++
+
+	release := func() error {
+		st.mu.Lock()
+		st.inflight--
+		st.mu.Unlock()
+		return nil
+	}
+	return release, true, nil
+}
+
+// Ответ сервиса, по которому пересчитывается его лимит
+func (l *adaptiveLimiter) Observe(upstream string, latency time.Duration, status int) {
+	st := l.state(upstream)
+
+	st.mu.Lock()
+	sample := Sample{
+		Latency:  latency,
+		Failed:   status >= http.StatusInternalServerError || status == http.StatusTooManyRequests,
+		Inflight: st.inflight,
+	}
+	st.limit = math.Max(l.min, math.Min(l.max, st.ctrl.Update(st.limit, sample)))
+	limit := st.limit
+	st.mu.Unlock()
+
+	if l.metric != nil {
+		l.metric.Set(upstream, limit)
+	}
+}
+
+func (l *adaptiveLimiter) state(key string) *adaptiveState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	st, ok := l.states[key]
+	if !ok {
+		st = &adaptiveState{limit: l.initial, ctrl: l.newController()}
+		l.states[key] = st
+		if l.metric != nil {
+			l.metric.Set(key, st.limit)
+		}
+	}
+	return st
+}
@@ -0,0 +1,156 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"gateway/server/interfaces"
+	"time"
+)
+
+// Лимитер, состояние которого можно просмотреть через хранилище
+type inspectable interface {
+	// Входы хранилища для состояний ключа
+	stateInputs(key string) []UpdateInput
+	storage() Storage
+}
+
+func (l *limiter) stateInputs(key string) []UpdateInput {
+	return []UpdateInput{{key, l.facade.name, l.facade.unmarsh}}
+}
+
+func (l *limiter) storage() Storage { return l.stor }
+
+func (l *tieredLimiter) stateInputs(key string) []UpdateInput {
+	inputs := make([]UpdateInput, len(l.tiers))
+	for i, t := range l.tiers {
+		inputs[i] = UpdateInput{key, t.Facade.name + ":" + t.Name, t.Facade.unmarsh}
+	}
+	return inputs
+}
+
+func (l *tieredLimiter) storage() Storage { return l.stor }
+
+func (l *concurrencyLimiter) stateInputs(key string) []UpdateInput {
+	return []UpdateInput{{key, l.name, l.unmarsh}}
+}
+
+func (l *concurrencyLimiter) storage() Storage { return l.stor }
+
+type admin struct {
+	lim    any
+	target inspectable
+	// nil - кэша отказов нет
+	denyCache *denyCacheLimiter
+	overrides *Overrides
+	// nil - блокировки выключены
+	bans *Bans
+}
+
+// lim - лимитер до обертки WithOverrides, bans может быть nil
+func NewAdmin(lim any, overrides *Overrides, bans *Bans) (interfaces.LimiterAdmin, error) {
+	var denyCache *denyCacheLimiter
+	switch c := lim.(type) {
+	case *denyCacheLimiter:
+		denyCache = c
+	case *denyCacheReservingLimiter:
+		denyCache = c.denyCacheLimiter
+	}
+	if denyCache != nil {
+		lim = denyCache.Limiter
+	}
+
+	target := lim
+	if h, ok := lim.(*hybridLimiter); ok {
+		target = h.remote
+	}
+	t, ok := target.(inspectable)
+	if !ok {
+		return nil, ErrInspectionNotSupported
+	}
+	return &admin{lim: lim, target: t, denyCache: denyCache, overrides: overrides, bans: bans}, nil
+}
+
+func (a *admin) Keys(ctx context.Context, prefix string, limit int) ([]string, error) {
+	var (
+		keys []string
+		seen = make(map[string]struct{})
+	)
+	// у многоуровневого лимитера ключ хранится в каждом уровне
+	for _, input := range a.target.stateInputs("") {
+		found, err := a.target.storage().Keys(ctx, input.Algorithm, prefix, limit-len(keys))
+		if err != nil {
+			return nil, fmt.Errorf("cannot list keys: %w", err)
+		}
+		for _, key := range found {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) >= limit {
+			break
+		}
+	}
+	return keys, nil
+}
+
+func (a *admin) State(ctx context.Context, key string) (map[string]any, error) {
+	var states map[string]any
+	for _, input := range a.target.stateInputs(key) {
+		s, err := a.target.storage().Get(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get state: %w", err)
+		}
+		if s == nil {
+			continue
+		}
+		if states == nil {
+			states = make(map[string]any)
+		}
+		states[input.Algorithm] = s.Params
+	}
+	return states, nil
+}
+
+// Сбрасывает состояние в хранилище. Квота, уже арендованная гибридным
+// лимитером, и кэш отказов сбрасываются только на этом экземпляре шлюза
+func (a *admin) Reset(ctx context.Context, key string) error {
+	for _, input := range a.target.stateInputs(key) {
+		if err := a.target.storage().Delete(ctx, input); err != nil {
+			return fmt.Errorf("cannot reset state: %w", err)
+		}
+	}
+	if h, ok := a.lim.(*hybridLimiter); ok {
+		h.forget(key)
+	}
+	if a.denyCache != nil {
+		a.denyCache.forget(key)
+	}
+	return nil
+}
+
+func (a *admin) Overrides(ctx context.Context) (map[string]interfaces.LimitOverride, error) {
+	return a.overrides.List(ctx)
+}
+
+func (a *admin) SetOverride(ctx context.Context, key string, override interfaces.LimitOverride) error {
+	return a.overrides.Set(ctx, key, override)
+}
+
+func (a *admin) DeleteOverride(ctx context.Context, key string) error {
+	return a.overrides.Delete(ctx, key)
+}
+
+func (a *admin) Bans(ctx context.Context) (map[string]time.Time, error) {
+	if a.bans == nil {
+		return nil, interfaces.ErrBansNotEnabled
+	}
+	return a.bans.List(ctx)
+}
+
+func (a *admin) Unban(ctx context.Context, key string) error {
+	if a.bans == nil {
+		return interfaces.ErrBansNotEnabled
+	}
+	return a.bans.Unban(ctx, key)
+}
@@ -0,0 +1,192 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Ключ блокируется на Duration, если получил Strikes отказов за Window
+type BanPolicy struct {
+	Strikes  int
+	Window   time.Duration
+	Duration time.Duration
+}
+
+// Временные блокировки ключей. Отказы копятся на экземпляре шлюза и раз
+// в flushInterval отправляются в хранилище одним обращением, поэтому отказ
+// не ждет хранилища. Запросы проверяются только по локальной копии блокировок:
+// экземпляр узнает о блокировке ключа из ответа на свои отказы, а блокировки
+// из копии перепроверяет при каждой отправке, чтобы снятые не действовали.
+// При недоступном хранилище отказы не учитываются
+type Bans struct {
+	stor   BanStorage
+	name   string
+	policy BanPolicy
+	clock  Clock
+	// вызывается, когда ключ заблокирован этим экземпляром
+	onBan func(key string, until time.Time)
+
+	mu      sync.Mutex
+	banned  map[string]time.Time
+	strikes map[string]int
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// name отделяет блокировки от блокировок других лимитеров в том же хранилище.
+// onBan может быть nil
+func NewBans(
+	name string, stor BanStorage, clock Clock, policy BanPolicy,
+	flushInterval time.Duration, onBan func(key string, until time.Time),
+) *Bans {
+	b := &Bans{
+		stor:    stor,
+		name:    name,
+		policy:  policy,
+		clock:   clock,
+		onBan:   onBan,
+		banned:  make(map[string]time.Time),
+		strikes: make(map[string]int),
+		stop:    make(chan struct{}),
+	}
+	go b.flushLoop(flushInterval)
+	return b
+}
+
+// Проверка по локальной копии, без обращения к хранилищу.
+// retryAfter - сколько осталось до окончания блокировки
+func (b *Bans) Banned(key string) (retryAfter time.Duration, ok bool) {
+	b.mu.Lock()
+	until, ok := b.banned[key]
+	b.mu.Unlock()
+
+	if !ok {
+		return 0, false
+	}
+	retryAfter = until.Sub(b.clock.Now())
+	return retryAfter, retryAfter > 0
+}
+
+// Учитывает отказ ключу, хранилище узнает о нем при следующей отправке
+func (b *Bans) Strike(key string) {
+	b.mu.Lock()
+	b.strikes[key]++
+	b.mu.Unlock()
+}
+
+func (b *Bans) List(ctx context.Context) (map[string]time.Time, error) {
+	bans, err := b.stor.Bans(ctx, b.name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read bans: %w", err)
+	}
+	return bans, nil
+}
+
+// Снимает блокировку и сбрасывает отказы ключа. Другие экземпляры
+// шлюза узнают о снятии при следующей отправке отказов
+func (b *Bans) Unban(ctx context.Context, key string) error {
+	if err := b.stor.Unban(ctx, b.name, key); err != nil {
+		return fmt.Errorf("cannot unban: %w", err)
+	}
+
+	b.mu.Lock()
+	delete(b.banned, key)
+	delete(b.strikes, key)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *Bans) Close() {
+	b.once.Do(func() { close(b.stop) })
+}
+
+func (b *Bans) flushLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			// при ошибке отказы теряются, а блокировки проверяются по прежней копии
+			b.flush(context.Background())
+		}
+	}
+}
+
+func (b *Bans) flush(ctx context.Context) error {
+	b.mu.Lock()
+	strikes := b.strikes
+	b.strikes = make(map[string]int)
+	cached := slices.Collect(maps.Keys(b.banned))
+	b.mu.Unlock()
+
+	if err := b.refresh(ctx, cached); err != nil {
+		return err
+	}
+	if len(strikes) == 0 {
+		return nil
+	}
+
+	counts, bans, err := b.stor.AddStrikes(ctx, b.name, strikes, b.policy.Window)
+	if err != nil {
+		return err
+	}
+	for key, count := range counts {
+		if _, ok := bans[key]; ok || count < b.policy.Strikes {
+			continue
+		}
+		until := b.clock.Now().Add(b.policy.Duration)
+		ok, err := b.stor.Ban(ctx, b.name, key, until, b.policy.Duration)
+		if err != nil {
+			return err
+		}
+		// иначе ключ только что заблокировал другой экземпляр,
+		// блокировка придет в ответе на следующий отказ
+		if ok {
+			bans[key] = until
+			if b.onBan != nil {
+				b.onBan(key, until)
+			}
+		}
+	}
+
+	b.mu.Lock()
+	maps.Copy(b.banned, bans)
+	b.mu.Unlock()
+	return nil
+}
+
+// Убирает из копии истекшие и снятые блокировки
+func (b *Bans) refresh(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	bans, err := b.stor.Bans(ctx, b.name, keys)
+	if err != nil {
+		return err
+	}
+
+	now := b.clock.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		// блокировку сняли на этом экземпляре во время чтения
+		if _, ok := b.banned[key]; !ok {
+			continue
+		}
+		until, ok := bans[key]
+		if !ok || !now.Before(until) {
+			delete(b.banned, key)
+		} else {
+			b.banned[key] = until
+		}
+	}
+	return nil
+}
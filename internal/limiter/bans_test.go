@@ -0,0 +1,105 @@
+package limiter_test
+
+import (
+	"context"
+	"gateway/internal/clock"
+	"gateway/internal/limiter"
+	"gateway/internal/storages"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Экземпляры шлюза одновременно учитывают отказы одного ключа в общем Redis:
+// ключ блокируется один раз по отказам всех экземпляров
+func TestBansConcurrentStrikes(t *testing.T) {
+	const (
+		instances = 4
+		perInst   = 5
+	)
+
+	_, rdb := newTestRedis(t)
+	ctx := context.Background()
+	policy := limiter.BanPolicy{Strikes: instances * perInst, Window: time.Minute, Duration: time.Hour}
+
+	var events atomic.Int32
+	onBan := func(string, time.Time) { events.Add(1) }
+
+	bans := make([]*limiter.Bans, instances)
+	for i := range bans {
+		stor := storages.NewRedisStorage(rdb, time.Minute)
+		bans[i] = limiter.NewBans("test", stor, clock.Real(), policy, 10*time.Millisecond, onBan)
+		t.Cleanup(bans[i].Close)
+	}
+
+	var wg sync.WaitGroup
+	for _, b := range bans {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perInst {
+				b.Strike("client")
+			}
+		}()
+	}
+	wg.Wait()
+
+	eventually(t, func() bool { return events.Load() == 1 })
+
+	// остальные экземпляры узнают о блокировке из ответа на свой отказ
+	for _, b := range bans {
+		if _, ok := b.Banned("client"); !ok {
+			b.Strike("client")
+		}
+	}
+	for i, b := range bans {
+		eventually(t, func() bool {
+			retryAfter, ok := b.Banned("client")
+			return ok && retryAfter > 59*time.Minute
+		})
+		if _, ok := b.Banned("other"); ok {
+			t.Errorf("instance %d: other key is banned", i)
+		}
+	}
+	if got := events.Load(); got != 1 {
+		t.Errorf("ban events = %d, want 1", got)
+	}
+
+	list, err := bans[1].List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if _, ok := list["client"]; !ok || len(list) != 1 {
+		t.Errorf("List() = %v, want client only", list)
+	}
+
+	if err = bans[0].Unban(ctx, "client"); err != nil {
+		t.Fatalf("Unban() error = %v", err)
+	}
+	for _, b := range bans {
+		eventually(t, func() bool {
+			_, ok := b.Banned("client")
+			return !ok
+		})
+	}
+}
+
+// Отказы, которые не набрали порог за окно, не блокируют ключ
+func TestBansStrikesExpireWithWindow(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	policy := limiter.BanPolicy{Strikes: 2, Window: time.Minute, Duration: time.Hour}
+	b := limiter.NewBans("test", storages.NewRedisStorage(rdb, 0), clock.Real(), policy, 10*time.Millisecond, nil)
+	t.Cleanup(b.Close)
+
+	b.Strike("client")
+	eventually(t, func() bool { return mr.Exists("strikes:test:client") })
+	mr.FastForward(time.Minute)
+
+	b.Strike("client")
+	eventually(t, func() bool { return mr.Exists("strikes:test:client") })
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := b.Banned("client"); ok {
+		t.Error("client banned by strikes from different windows")
+	}
+}
@@ -0,0 +1,104 @@
+package limiter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"gateway/server/interfaces"
+	"time"
+)
+
+type concurrencyLimiter struct {
+	alg     LeaseAlgorithm
+	unmarsh Unmarshaler[State]
+	name    string
+	stor    Storage
+}
+
+func NewConcurrencyLimiter(
+	name string, alg LeaseAlgorithm, unmarsh Unmarshaler[State], stor Storage,
+) interfaces.ConcurrencyLimiter {
+	return &concurrencyLimiter{
+		alg:     alg,
+		unmarsh: unmarsh,
+		name:    name,
+		stor:    stor,
+	}
+}
+
+func (l *concurrencyLimiter) Acquire(ctx context.Context, key string) (func() error, bool, error) {
+	id, err := newLeaseID()
+	if err != nil {
+		return nil, false, err
+	}
+	input := UpdateInput{key, l.name, l.unmarsh}
+
+	var ok bool
+	err = l.stor.Update(
+		ctx,
+		input,
+		func(s *State) (new *State, err error) {
+			if s == nil {
+				s = l.alg.FirstState()
+			}
+			ok, new, err = l.alg.Acquire(s, id)
+			return new, err
+		},
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot acquire slot: %w", err)
+	}
+	if !ok {
+		return nil, false, nil
+	}
+
+	// аренда продлевается, пока запрос выполняется,
+	// и освобождается даже после отмены контекста запроса
+	ctx = context.WithoutCancel(ctx)
+	done := make(chan struct{})
+	go l.renew(ctx, input, id, done)
+
+	release := func() error {
+		close(done)
+		err := l.stor.Update(ctx, input, func(s *State) (*State, error) {
+			if s == nil {
+				s = l.alg.FirstState()
+			}
+			return l.alg.Release(s, id)
+		})
+		if err != nil {
+			return fmt.Errorf("cannot release slot: %w", err)
+		}
+		return nil
+	}
+	return release, true, nil
+}
+
+func (l *concurrencyLimiter) renew(ctx context.Context, input UpdateInput, id string, done <-chan struct{}) {
+	ticker := time.NewTicker(l.alg.LeaseTTL() / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			// при ошибке аренда истечет сама, слот будет освобожден
+			l.stor.Update(ctx, input, func(s *State) (*State, error) {
+				if s == nil {
+					s = l.alg.FirstState()
+				}
+				return l.alg.Renew(s, id)
+			})
+		}
+	}
+}
+
+func newLeaseID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
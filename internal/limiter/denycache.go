@@ -0,0 +1,165 @@
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"gateway/server/interfaces"
+	"sync"
+	"time"
+)
+
+// Отказ, который действует до until для запросов стоимостью не меньше cost
+type denial struct {
+	key      string
+	cost     int
+	decision interfaces.Decision
+	until    time.Time
+}
+
+type denyCacheLimiter struct {
+	interfaces.Limiter
+	size   int
+	metric interfaces.DenyCacheMetric
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// Лимитер с локальным кэшем отказов: если lim отклонил запрос и сообщил RetryAfter,
+// запросы ключа с той же или большей стоимостью отклоняются без обращения
+// к хранилищу, пока квота не восстановится. Кэш хранит не больше size ключей,
+// при переполнении вытесняются давно использованные.
+// Если lim возвращает квоту, результат тоже ее возвращает
+func WithDenyCache(lim interfaces.Limiter, size int, metric interfaces.DenyCacheMetric) interfaces.Limiter {
+	c := &denyCacheLimiter{
+		Limiter: lim,
+		size:    size,
+		metric:  metric,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	if res, ok := lim.(interfaces.ReservingLimiter); ok {
+		return &denyCacheReservingLimiter{denyCacheLimiter: c, reserving: res}
+	}
+	return c
+}
+
+func (c *denyCacheLimiter) Allow(ctx context.Context, key string) (interfaces.Decision, error) {
+	return c.AllowN(ctx, key, 1)
+}
+
+func (c *denyCacheLimiter) AllowN(ctx context.Context, key string, n int) (interfaces.Decision, error) {
+	if d, ok := c.lookup(key, n); ok {
+		return d, nil
+	}
+	d, err := c.Limiter.AllowN(ctx, key, n)
+	if err != nil {
+		return d, err
+	}
+	c.store(key, n, d)
+	return d, nil
+}
+
+// n = 0 - проверка без расхода квоты, ее кэш не отклоняет
+func (c *denyCacheLimiter) lookup(key string, n int) (interfaces.Decision, bool) {
+	if n <= 0 {
+		return interfaces.Decision{}, false
+	}
+
+	now := time.Now()
+	var (
+		d   interfaces.Decision
+		hit bool
+	)
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*denial)
+		switch {
+		case !now.Before(e.until):
+			c.remove(el)
+		case n >= e.cost:
+			d, hit = e.decision, true
+			d.RetryAfter = e.until.Sub(now)
+			c.lru.MoveToFront(el)
+		}
+	}
+	c.mu.Unlock()
+
+	c.metric.Inc(hit)
+	return d, hit
+}
+
+func (c *denyCacheLimiter) store(key string, n int, d interfaces.Decision) {
+	if d.Allowed || d.RetryAfter <= 0 {
+		// квота есть или алгоритм не сообщает, когда она появится
+		if d.Allowed {
+			c.forget(key)
+		}
+		return
+	}
+
+	e := &denial{key: key, cost: n, decision: d, until: time.Now().Add(d.RetryAfter)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	if c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Квота ключа могла появиться раньше срока: сброс или возврат квоты
+func (c *denyCacheLimiter) forget(key string) {
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.mu.Unlock()
+}
+
+func (c *denyCacheLimiter) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*denial).key)
+}
+
+type denyCacheReservingLimiter struct {
+	*denyCacheLimiter
+	reserving interfaces.ReservingLimiter
+}
+
+func (l *denyCacheReservingLimiter) Reserve(
+	ctx context.Context, key string, n int,
+) (interfaces.Decision, interfaces.Reservation, error) {
+	if d, ok := l.lookup(key, n); ok {
+		return d, nil, nil
+	}
+	d, res, err := l.reserving.Reserve(ctx, key, n)
+	if err != nil {
+		return d, res, err
+	}
+	l.store(key, n, d)
+	if !d.Allowed {
+		return d, res, nil
+	}
+	return d, &denyCacheReservation{Reservation: res, cache: l.denyCacheLimiter, key: key}, nil
+}
+
+type denyCacheReservation struct {
+	interfaces.Reservation
+	cache *denyCacheLimiter
+	key   string
+}
+
+// Возвращенная квота снимает отказ, закэшированный после резервирования
+func (r *denyCacheReservation) Cancel(ctx context.Context) error {
+	err := r.Reservation.Cancel(ctx)
+	r.cache.forget(r.key)
+	return err
+}
@@ -0,0 +1,215 @@
+package limiter_test
+
+import (
+	"context"
+	"gateway/internal/limiter"
+	"gateway/server/interfaces"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Лимитер, который отклоняет ключи из deny и считает обращения
+type stubLimiter struct {
+	mu    sync.Mutex
+	deny  map[string]time.Duration
+	calls map[string]int
+}
+
+func newStubLimiter() *stubLimiter {
+	return &stubLimiter{deny: make(map[string]time.Duration), calls: make(map[string]int)}
+}
+
+func (l *stubLimiter) setDeny(key string, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if retryAfter > 0 {
+		l.deny[key] = retryAfter
+	} else {
+		delete(l.deny, key)
+	}
+}
+
+func (l *stubLimiter) callsOf(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls[key]
+}
+
+func (l *stubLimiter) Allow(ctx context.Context, key string) (interfaces.Decision, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *stubLimiter) AllowN(_ context.Context, key string, _ int) (interfaces.Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls[key]++
+	if retryAfter, ok := l.deny[key]; ok {
+		return interfaces.Decision{RetryAfter: retryAfter}, nil
+	}
+	return interfaces.Decision{Allowed: true}, nil
+}
+
+func (l *stubLimiter) Reserve(ctx context.Context, key string, n int) (interfaces.Decision, interfaces.Reservation, error) {
+	d, err := l.AllowN(ctx, key, n)
+	if err != nil || !d.Allowed {
+		return d, nil, err
+	}
+	return d, stubReservation{}, nil
+}
+
+type stubReservation struct{}
+
+func (stubReservation) Commit()                      {}
+func (stubReservation) Cancel(context.Context) error { return nil }
+
+type stubDenyCacheMetric struct {
+	mu        sync.Mutex
+	hits, all int
+}
+
+func (m *stubDenyCacheMetric) Inc(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.all++
+	if hit {
+		m.hits++
+	}
+}
+
+func TestDenyCache(t *testing.T) {
+	ctx := context.Background()
+
+	allowN := func(t *testing.T, lim interfaces.Limiter, key string, n int) interfaces.Decision {
+		t.Helper()
+		d, err := lim.AllowN(ctx, key, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	t.Run("hit and miss", func(t *testing.T) {
+		stub := newStubLimiter()
+		metric := &stubDenyCacheMetric{}
+		lim := limiter.WithDenyCache(stub, 10, metric)
+
+		stub.setDeny("a", time.Hour)
+		if d := allowN(t, lim, "a", 1); d.Allowed {
+			t.Fatal("expected denial")
+		}
+		d := allowN(t, lim, "a", 1)
+		if d.Allowed || d.RetryAfter <= 0 || d.RetryAfter > time.Hour {
+			t.Fatalf("cached decision = %+v", d)
+		}
+		if got := stub.callsOf("a"); got != 1 {
+			t.Fatalf("limiter calls = %d, want 1", got)
+		}
+
+		// разрешенные ключи не кэшируются
+		allowN(t, lim, "b", 1)
+		allowN(t, lim, "b", 1)
+		if got := stub.callsOf("b"); got != 2 {
+			t.Fatalf("limiter calls = %d, want 2", got)
+		}
+		if metric.hits != 1 || metric.all != 4 {
+			t.Fatalf("metric hits/all = %d/%d, want 1/4", metric.hits, metric.all)
+		}
+	})
+
+	t.Run("expires at until", func(t *testing.T) {
+		const retryAfter = 50 * time.Millisecond
+		stub := newStubLimiter()
+		lim := limiter.WithDenyCache(stub, 10, &stubDenyCacheMetric{})
+
+		stub.setDeny("a", retryAfter)
+		start := time.Now()
+		allowN(t, lim, "a", 1)
+		stub.setDeny("a", 0)
+
+		for !allowN(t, lim, "a", 1).Allowed {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if elapsed := time.Since(start); elapsed < retryAfter {
+			t.Fatalf("denial expired after %v, want at least %v", elapsed, retryAfter)
+		}
+	})
+
+	t.Run("cheaper requests bypass", func(t *testing.T) {
+		stub := newStubLimiter()
+		lim := limiter.WithDenyCache(stub, 10, &stubDenyCacheMetric{})
+
+		stub.setDeny("a", time.Hour)
+		allowN(t, lim, "a", 5)
+		allowN(t, lim, "a", 5)
+		allowN(t, lim, "a", 7)
+		if got := stub.callsOf("a"); got != 1 {
+			t.Fatalf("limiter calls = %d, want 1", got)
+		}
+		// меньшая стоимость может уложиться в остаток квоты
+		allowN(t, lim, "a", 4)
+		if got := stub.callsOf("a"); got != 2 {
+			t.Fatalf("limiter calls = %d, want 2", got)
+		}
+		// проверка без расхода квоты не отклоняется кэшем
+		allowN(t, lim, "a", 0)
+		if got := stub.callsOf("a"); got != 3 {
+			t.Fatalf("limiter calls = %d, want 3", got)
+		}
+	})
+
+	t.Run("evicts least recently used", func(t *testing.T) {
+		stub := newStubLimiter()
+		lim := limiter.WithDenyCache(stub, 2, &stubDenyCacheMetric{})
+
+		for _, key := range []string{"a", "b", "c"} {
+			stub.setDeny(key, time.Hour)
+		}
+		allowN(t, lim, "a", 1)
+		allowN(t, lim, "b", 1)
+		allowN(t, lim, "a", 1) // a используется позже b
+		allowN(t, lim, "c", 1) // вытесняет b
+
+		for key, want := range map[string]int{"a": 1, "b": 1, "c": 1} {
+			if got := stub.callsOf(key); got != want {
+				t.Fatalf("%s: limiter calls = %d, want %d", key, got, want)
+			}
+		}
+		allowN(t, lim, "a", 1)
+		allowN(t, lim, "c", 1)
+		allowN(t, lim, "b", 1)
+		for key, want := range map[string]int{"a": 1, "b": 2, "c": 1} {
+			if got := stub.callsOf(key); got != want {
+				t.Fatalf("%s: limiter calls = %d, want %d", key, got, want)
+			}
+		}
+	})
+
+	t.Run("cancel clears entry", func(t *testing.T) {
+		stub := newStubLimiter()
+		lim := limiter.WithDenyCache(stub, 10, &stubDenyCacheMetric{}).(interfaces.ReservingLimiter)
+
+		_, res, err := lim.Reserve(ctx, "a", 1)
+		if err != nil || res == nil {
+			t.Fatalf("reserve: %v, %v", res, err)
+		}
+		stub.setDeny("a", time.Hour)
+		allowN(t, lim, "a", 1)
+		allowN(t, lim, "a", 1)
+		if got := stub.callsOf("a"); got != 2 {
+			t.Fatalf("limiter calls = %d, want 2", got)
+		}
+
+		// возврат квоты снимает отказ, закэшированный после резервирования
+		stub.setDeny("a", 0)
+		if err := res.Cancel(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if d := allowN(t, lim, "a", 1); !d.Allowed {
+			t.Fatal("expected cancel to clear cached denial")
+		}
+		if got := stub.callsOf("a"); got != 3 {
+			t.Fatalf("limiter calls = %d, want 3", got)
+		}
+	})
+}
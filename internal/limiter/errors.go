@@ -5,4 +5,8 @@ import "errors"
 var (
 	ErrInvalidState  = errors.New("invalid state")
 	ErrStateNotFount = errors.New("state not found")
+
+	ErrShapingNotSupported = errors.New("algorithm does not support shaping")
+
+	ErrInspectionNotSupported = errors.New("limiter does not support inspection")
 )
@@ -0,0 +1,15 @@
+package limiter
+
+// Идет ли фоновая аренда ключа
+func (h *hybridLimiter) Refilling(key string) bool {
+	h.mu.Lock()
+	l, ok := h.leases[key]
+	h.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.refilling
+}
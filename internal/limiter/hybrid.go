@@ -0,0 +1,195 @@
+package limiter
+
+import (
+	"context"
+	"gateway/server/interfaces"
+	"sync"
+	"time"
+)
+
+type lease struct {
+	mu sync.Mutex
+	// единицы квоты, уже списанные в общем хранилище
+	tokens  int
+	expires time.Time
+	// решение общего лимитера при последней аренде
+	last      interfaces.Decision
+	refilling bool
+}
+
+type hybridLimiter struct {
+	remote       interfaces.Limiter
+	batch        int
+	syncInterval time.Duration
+
+	mu     sync.Mutex
+	leases map[string]*lease
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// Приближенный распределенный лимитер: экземпляр шлюза арендует у remote
+// пачки по batch единиц и расходует их локально. Когда в аренде остается меньше
+// половины пачки, следующая пачка запрашивается в фоне. Аренда действует
+// syncInterval, неиспользованные единицы пропадают.
+// Каждый экземпляр может пропустить сверх лимита не больше 2*batch единиц
+// за syncInterval - единицы, арендованные до восстановления квоты.
+// Истекшие аренды удаляются в фоне, пока лимитер не закрыт Close
+func NewHybridLimiter(remote interfaces.Limiter, batch int, syncInterval time.Duration) *hybridLimiter {
+	h := &hybridLimiter{
+		remote:       remote,
+		batch:        batch,
+		syncInterval: syncInterval,
+		leases:       make(map[string]*lease),
+		stop:         make(chan struct{}),
+	}
+	go h.cleanup()
+	return h
+}
+
+func (h *hybridLimiter) Allow(ctx context.Context, key string) (interfaces.Decision, error) {
+	return h.AllowN(ctx, key, 1)
+}
+
+func (h *hybridLimiter) AllowN(ctx context.Context, key string, n int) (interfaces.Decision, error) {
+	l := h.lease(key)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Now().After(l.expires) {
+		l.tokens = 0
+	}
+	// проверка без списания при пустой аренде - по общей квоте,
+	// а не по остатку на момент прошлой аренды
+	if n == 0 && l.tokens == 0 {
+		return h.remote.AllowN(ctx, key, 0)
+	}
+
+	if l.tokens < n {
+		d, got, err := h.take(ctx, key, n)
+		if err != nil {
+			return interfaces.Decision{}, err
+		}
+		if !d.Allowed {
+			return d, nil
+		}
+		l.add(d, got, h.syncInterval)
+	}
+
+	l.tokens -= n
+	if l.tokens < h.batch/2 && !l.refilling {
+		l.refilling = true
+		go h.refill(key, l)
+	}
+	return l.decision(), nil
+}
+
+// Арендует пачку, но не меньше need. Если квоты на пачку нет,
+// арендует сколько осталось
+func (h *hybridLimiter) take(ctx context.Context, key string, need int) (interfaces.Decision, int, error) {
+	want := max(h.batch, need)
+	d, err := h.remote.AllowN(ctx, key, want)
+	if err != nil || d.Allowed {
+		return d, want, err
+	}
+
+	if d.Remaining < int64(need) {
+		return d, 0, nil
+	}
+	want = int(d.Remaining)
+	d, err = h.remote.AllowN(ctx, key, want)
+	return d, want, err
+}
+
+func (h *hybridLimiter) refill(key string, l *lease) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.syncInterval)
+	defer cancel()
+
+	d, got, err := h.take(ctx, key, 1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refilling = false
+	if err == nil && d.Allowed {
+		l.add(d, got, h.syncInterval)
+	}
+}
+
+func (h *hybridLimiter) lease(key string) *lease {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	l, ok := h.leases[key]
+	if !ok {
+		l = &lease{}
+		h.leases[key] = l
+	}
+	return l
+}
+
+// Отбрасывает аренду ключа, следующий запрос арендует квоту заново
+func (h *hybridLimiter) forget(key string) {
+	h.mu.Lock()
+	l, ok := h.leases[key]
+	h.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	l.tokens, l.expires = 0, time.Time{}
+	l.mu.Unlock()
+}
+
+// Удаляет истекшие аренды, чтобы не хранить ключи неактивных клиентов
+func (h *hybridLimiter) cleanup() {
+	ticker := time.NewTicker(h.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case now := <-ticker.C:
+			h.removeExpired(now)
+		}
+	}
+}
+
+func (h *hybridLimiter) removeExpired(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, l := range h.leases {
+		l.mu.Lock()
+		if !l.refilling && now.After(l.expires) {
+			delete(h.leases, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (h *hybridLimiter) Close() {
+	h.once.Do(func() { close(h.stop) })
+}
+
+func (l *lease) add(d interfaces.Decision, tokens int, ttl time.Duration) {
+	if time.Now().After(l.expires) {
+		l.tokens = 0
+	}
+	l.tokens += tokens
+	l.expires = time.Now().Add(ttl)
+	l.last = d
+}
+
+// Приближенное решение: остаток общей квоты на момент аренды и неиспользованная аренда
+func (l *lease) decision() interfaces.Decision {
+	d := l.last
+	d.Allowed = true
+	d.Remaining += int64(l.tokens)
+	d.RetryAfter = 0
+	return d
+}
@@ -0,0 +1,115 @@
+package limiter_test
+
+import (
+	"context"
+	"gateway/internal/algorithm"
+	"gateway/internal/algorithm/fixedwindow"
+	"gateway/internal/clock"
+	"gateway/internal/limiter"
+	"gateway/internal/storages"
+	"gateway/server/interfaces"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Несколько экземпляров шлюза с гибридным лимитером над одним хранилищем.
+// За syncInterval каждый экземпляр пропускает сверх лимита не больше 2*batch
+func TestHybridLimiterOvershootBound(t *testing.T) {
+	const (
+		limit     = 100
+		batch     = 10
+		instances = 4
+		windows   = 5
+		// запросов каждого экземпляра за окно: в четных окнах экземпляры
+		// не расходуют аренды и переносят их в следующее окно, в нечетных
+		// расходуют и остаток аренд, и квоту нового окна
+		light = 15
+		heavy = 200
+	)
+
+	stor := storages.NewMemoryStorage(0, 0, 0)
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0).Truncate(time.Minute))
+	remote := limiter.NewLimiter(
+		limiter.NewFacade(
+			"fixed_window",
+			fixedwindow.NewFixedWindow(limit, time.Minute, clk),
+			algorithm.NewStateUnmarshaler[fixedwindow.Params](),
+		),
+		stor,
+	)
+
+	// аренды не истекают до конца теста: все окна укладываются в один syncInterval
+	lims := make([]interfaces.Limiter, instances)
+	for i := range lims {
+		h := limiter.NewHybridLimiter(remote, batch, time.Hour)
+		t.Cleanup(h.Close)
+		lims[i] = h
+	}
+
+	var total int
+	for w := range windows {
+		var allowed atomic.Int32
+		var wg sync.WaitGroup
+		sent := heavy
+		if w%2 == 0 {
+			sent = light
+		}
+		for _, lim := range lims {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range sent {
+					d, err := lim.Allow(context.Background(), "client")
+					if err != nil {
+						t.Errorf("Allow() error = %v", err)
+						return
+					}
+					if d.Allowed {
+						allowed.Add(1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		got := int(allowed.Load())
+		if maxAllowed := limit + 2*batch*instances; got > maxAllowed {
+			t.Errorf("window %d: allowed = %d, want at most %d", w, got, maxAllowed)
+		}
+		if w == 0 && got > limit {
+			// квота еще не восстанавливалась, превышать лимит нечем
+			t.Errorf("window 0: allowed = %d, want at most %d", got, limit)
+		}
+		t.Logf("window %d: allowed = %d", w, got)
+		total += got
+
+		// фоновые аренды, начатые в этом окне, должны завершиться до его конца
+		eventually(t, func() bool {
+			for _, lim := range lims {
+				if lim.(interface{ Refilling(key string) bool }).Refilling("client") {
+					return false
+				}
+			}
+			return true
+		})
+		clk.Advance(time.Minute)
+	}
+
+	// экземпляры расходуют только арендованную квоту, а аренды берутся из общей
+	if maxTotal := windows * limit; total > maxTotal {
+		t.Errorf("total allowed = %d, want at most %d", total, maxTotal)
+	}
+}
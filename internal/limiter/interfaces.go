@@ -1,6 +1,10 @@
 package limiter
 
-import "context"
+import (
+	"context"
+	"gateway/server/interfaces"
+	"time"
+)
 
 type Marshaler interface {
 	Marshal() ([]byte, error)
@@ -10,13 +14,79 @@ type Unmarshaler[T any] interface {
 	Unmarshal(data []byte) (*T, error)
 }
 
+// Источник времени алгоритмов. У экземпляров шлюза с общим хранилищем
+// часы должны совпадать, иначе окна и пополнение квоты у них расходятся
+type Clock = interfaces.Clock
+
 type State struct {
 	Params Marshaler
 }
 
+// cost - сколько единиц квоты расходует запрос
 type Algorithm interface {
 	FirstState() *State
-	Action(state *State) (bool, *State, error)
+	Action(state *State, cost int) (interfaces.Decision, *State, error)
+}
+
+// Алгоритм, который может вернуть квоту. reserved - решение,
+// с которым квота была выдана: по нему видно, не сменилось ли окно
+type RefundAlgorithm interface {
+	Algorithm
+	Refund(state *State, cost int, reserved interfaces.Decision) (*State, error)
+}
+
+// Алгоритм, который вместо отказа назначает время, до которого запрос нужно задержать
+type ShapingAlgorithm interface {
+	Algorithm
+	Schedule(state *State, cost int) (until time.Time, decision interfaces.Decision, new *State, err error)
+}
+
+// Запрос к журналу скользящего окна, который ведет хранилище
+type LogRequest struct {
+	Now    time.Time
+	Window time.Duration
+	Limit  int
+	Cost   int
+}
+
+type LogResult struct {
+	Allowed bool
+	// запросов в окне после решения
+	Count int
+	// время последнего запроса в журнале, нулевое - журнал пуст
+	Newest time.Time
+	// для отклоненного запроса - время записи, после истечения которой
+	// квоты хватит, нулевое - квоты не хватит никогда
+	Release time.Time
+}
+
+// Алгоритм, который может работать по журналу, который ведет хранилище,
+// не читая журнал целиком
+type LogAlgorithm interface {
+	Algorithm
+	LogRequest(cost int) LogRequest
+	LogDecision(req LogRequest, res LogResult) interfaces.Decision
+}
+
+// Алгоритм ограничения числа одновременных запросов на арендах слотов.
+// Аренда, которую не продлили, истекает через LeaseTTL
+type LeaseAlgorithm interface {
+	FirstState() *State
+	LeaseTTL() time.Duration
+	Acquire(state *State, id string) (bool, *State, error)
+	Renew(state *State, id string) (*State, error)
+	Release(state *State, id string) (*State, error)
+}
+
+// Ответ сервиса, по которому адаптивный лимитер пересчитывает лимит
+type Sample struct {
+	Latency  time.Duration
+	Failed   bool
+	Inflight int
+}
+
+type LimitController interface {
+	Update(limit float64, sample Sample) float64
 }
 
 type UpdateInput struct {
@@ -27,6 +97,47 @@ type UpdateInput struct {
 
 type Storage interface {
 	Update(ctx context.Context, input UpdateInput, update UpdateFunc) error
+	// Атомарное обновление нескольких состояний за одно обращение к хранилищу
+	UpdateMulti(ctx context.Context, inputs []UpdateInput, update MultiUpdateFunc) error
+
+	// Состояние ключа без обновления, nil - состояния нет
+	Get(ctx context.Context, input UpdateInput) (*State, error)
+	Delete(ctx context.Context, input UpdateInput) error
+	// Ключи с префиксом prefix, для которых хранится состояние алгоритма, не больше limit
+	Keys(ctx context.Context, algorithm, prefix string, limit int) ([]string, error)
+
+	// Состояния, общие для экземпляров шлюза (исключения, блокировки): обновляются
+	// атомарно для всех экземпляров в любом режиме хранилища и живут ttl вместо
+	// времени жизни ключей (0 - без срока). Резервное хранилище для них не используется
+	GetShared(ctx context.Context, input UpdateInput) (*State, error)
+	UpdateShared(ctx context.Context, input UpdateInput, ttl time.Duration, update UpdateFunc) error
+}
+
+// Хранилище блокировок. Блокировка и счетчик отказов ключа - отдельные записи
+// со своим сроком, поэтому экземпляры шлюза не конкурируют за общую запись
+type BanStorage interface {
+	// Добавляет отказы ключам: strikes - число новых отказов по ключам.
+	// Возвращает отказы ключей за окно window, которое начинается с первого
+	// отказа, и время окончания блокировки уже заблокированных ключей
+	AddStrikes(ctx context.Context, name string, strikes map[string]int, window time.Duration) (
+		counts map[string]int, bans map[string]time.Time, err error,
+	)
+	// Блокирует ключ до until на ttl и сбрасывает его отказы. false - ключ уже заблокирован
+	Ban(ctx context.Context, name, key string, until time.Time, ttl time.Duration) (bool, error)
+	// Время окончания блокировки ключей keys, nil - всех заблокированных ключей
+	Bans(ctx context.Context, name string, keys []string) (map[string]time.Time, error)
+	Unban(ctx context.Context, name, key string) error
+}
+
+// Хранилище, которое само ведет журнал LogAlgorithm (например, ZSET в Redis).
+// native = false - журнал не поддерживается или хранилище недоступно,
+// и вместо него состояние обновлено через update
+type LogStorage interface {
+	UpdateLog(ctx context.Context, input UpdateInput, req LogRequest, update UpdateFunc) (res LogResult, native bool, err error)
 }
 
 type UpdateFunc func(*State) (new *State, err error)
+
+// Состояния передаются в порядке inputs. nil - ничего не записывать,
+// nil элемент - не записывать это состояние
+type MultiUpdateFunc func([]*State) (new []*State, err error)
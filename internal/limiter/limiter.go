@@ -11,27 +11,47 @@ type limiter struct {
 	stor   Storage
 }
 
+// Если алгоритм умеет возвращать квоту, лимитер реализует interfaces.ReservingLimiter
 func NewLimiter(facade *AlgorithmFacade, stor Storage) interfaces.Limiter {
-	return &limiter{facade: facade, stor: stor}
+	l := &limiter{facade: facade, stor: stor}
+	if alg, ok := facade.Algorithm.(RefundAlgorithm); ok {
+		return &reservingLimiter{limiter: l, alg: alg}
+	}
+	return l
+}
+
+func (l *limiter) Allow(ctx context.Context, key string) (interfaces.Decision, error) {
+	return l.AllowN(ctx, key, 1)
 }
 
-func (l *limiter) Allow(ctx context.Context, key string) (bool, error) {
+func (l *limiter) AllowN(ctx context.Context, key string, n int) (interfaces.Decision, error) {
 	input := UpdateInput{key, l.facade.name, l.facade.unmarsh}
 
-	var allow bool
-	err := l.stor.Update(
-		ctx,
-		input,
-		func(s *State) (new *State, err error) {
-			if s == nil {
-				s = l.facade.FirstState()
-			}
-			allow, new, err = l.facade.Action(s)
-			return new, err
-		},
-	)
+	var decision interfaces.Decision
+	update := func(s *State) (new *State, err error) {
+		if s == nil {
+			s = l.facade.FirstState()
+		}
+		decision, new, err = l.facade.Action(s, n)
+		return new, err
+	}
+
+	alg, isLog := l.facade.Algorithm.(LogAlgorithm)
+	stor, hasLog := l.stor.(LogStorage)
+	if !isLog || !hasLog {
+		if err := l.stor.Update(ctx, input, update); err != nil {
+			return interfaces.Decision{}, fmt.Errorf("cannot update state: %w", err)
+		}
+		return decision, nil
+	}
+
+	req := alg.LogRequest(n)
+	res, native, err := stor.UpdateLog(ctx, input, req, update)
 	if err != nil {
-		return false, fmt.Errorf("cannot update state: %w", err)
+		return interfaces.Decision{}, fmt.Errorf("cannot update log: %w", err)
+	}
+	if native {
+		decision = alg.LogDecision(req, res)
 	}
-	return allow, nil
+	return decision, nil
 }
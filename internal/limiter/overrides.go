@@ -0,0 +1,177 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"gateway/server/interfaces"
+	"time"
+)
+
+const overridesAlgorithm = "overrides"
+
+// Алгоритм для LimitOverride.Limit: не больше limit единиц квоты за window
+type CustomLimit func(limit int, window time.Duration) (Algorithm, Unmarshaler[State])
+
+// Временные исключения лимитера. Хранятся одной записью в хранилище лимитера,
+// экземпляр шлюза перечитывает ее раз в syncInterval и проверяет запросы по своей копии
+type Overrides struct {
+	stor    Storage
+	entries *sharedMap[interfaces.LimitOverride]
+	// nil - поддерживается только Exempt
+	custom CustomLimit
+}
+
+// name отделяет запись исключений от записей других лимитеров в том же хранилище
+func NewOverrides(name string, stor Storage, custom CustomLimit, syncInterval time.Duration) *Overrides {
+	expires := func(ov interfaces.LimitOverride) time.Time { return ov.Expires }
+	return &Overrides{
+		stor:    stor,
+		entries: newSharedMap(name, overridesAlgorithm, stor, expires, syncInterval),
+		custom:  custom,
+	}
+}
+
+// Останавливает синхронизацию исключений
+func (o *Overrides) Close() {
+	o.entries.close()
+}
+
+func (o *Overrides) List(ctx context.Context) (map[string]interfaces.LimitOverride, error) {
+	overrides, err := o.entries.list(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read overrides: %w", err)
+	}
+	return overrides, nil
+}
+
+func (o *Overrides) Set(ctx context.Context, key string, override interfaces.LimitOverride) error {
+	if !override.Exempt {
+		if o.custom == nil {
+			return fmt.Errorf("%w: custom limit", interfaces.ErrOverrideNotSupported)
+		}
+		if override.Limit < 1 || override.Window <= 0 {
+			return fmt.Errorf("%w: limit and window must be positive", interfaces.ErrInvalidOverride)
+		}
+	}
+	return o.modify(ctx, func(entries map[string]interfaces.LimitOverride) {
+		entries[key] = override
+	})
+}
+
+func (o *Overrides) Delete(ctx context.Context, key string) error {
+	return o.modify(ctx, func(entries map[string]interfaces.LimitOverride) {
+		delete(entries, key)
+	})
+}
+
+func (o *Overrides) modify(ctx context.Context, change func(map[string]interfaces.LimitOverride)) error {
+	if err := o.entries.modify(ctx, change); err != nil {
+		return fmt.Errorf("cannot update overrides: %w", err)
+	}
+	return nil
+}
+
+// Решение по исключению ключа, ok = false - исключения нет
+func (o *Overrides) allow(ctx context.Context, key string, n int) (d interfaces.Decision, ok bool, err error) {
+	ov, ok := o.entries.get(key)
+	if !ok {
+		return interfaces.Decision{}, false, nil
+	}
+	if ov.Exempt || o.custom == nil {
+		return interfaces.Decision{Allowed: true}, true, nil
+	}
+
+	// состояние отделяется параметрами, чтобы измененный лимит начинался с чистого окна
+	alg, unmarsh := o.custom(ov.Limit, ov.Window)
+	name := fmt.Sprintf("override:%d:%s", ov.Limit, ov.Window)
+	l := &limiter{facade: NewFacade(name, alg, unmarsh), stor: o.stor}
+
+	d, err = l.AllowN(ctx, key, n)
+	return d, true, err
+}
+
+type overrideLimiter struct {
+	interfaces.Limiter
+	overrides *Overrides
+}
+
+// Лимитер, который для ключей с исключением применяет исключение вместо lim.
+// Если lim возвращает квоту, результат тоже ее возвращает
+func WithOverrides(lim interfaces.Limiter, overrides *Overrides) interfaces.Limiter {
+	l := &overrideLimiter{Limiter: lim, overrides: overrides}
+	if res, ok := lim.(interfaces.ReservingLimiter); ok {
+		return &overrideReservingLimiter{overrideLimiter: l, reserving: res}
+	}
+	return l
+}
+
+func (l *overrideLimiter) Allow(ctx context.Context, key string) (interfaces.Decision, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *overrideLimiter) AllowN(ctx context.Context, key string, n int) (interfaces.Decision, error) {
+	if d, ok, err := l.overrides.allow(ctx, key, n); ok {
+		return d, err
+	}
+	return l.Limiter.AllowN(ctx, key, n)
+}
+
+type overrideReservingLimiter struct {
+	*overrideLimiter
+	reserving interfaces.ReservingLimiter
+}
+
+// Квота по исключению не возвращается
+func (l *overrideReservingLimiter) Reserve(
+	ctx context.Context, key string, n int,
+) (interfaces.Decision, interfaces.Reservation, error) {
+	if d, ok, err := l.overrides.allow(ctx, key, n); ok {
+		if err != nil || !d.Allowed {
+			return d, nil, err
+		}
+		return d, noopReservation{}, nil
+	}
+	return l.reserving.Reserve(ctx, key, n)
+}
+
+type overrideShaper struct {
+	overrideLimiter
+	shaper interfaces.Shaper
+}
+
+// Запрос с исключением не задерживается
+func ShaperWithOverrides(shaper interfaces.Shaper, overrides *Overrides) interfaces.Shaper {
+	return &overrideShaper{
+		overrideLimiter: overrideLimiter{Limiter: shaper, overrides: overrides},
+		shaper:          shaper,
+	}
+}
+
+func (s *overrideShaper) Wait(
+	ctx context.Context, key string, n int,
+) (time.Time, interfaces.Decision, interfaces.Reservation, error) {
+	if d, ok, err := s.overrides.allow(ctx, key, n); ok {
+		if err != nil || !d.Allowed {
+			return time.Time{}, d, nil, err
+		}
+		return time.Time{}, d, noopReservation{}, nil
+	}
+	return s.shaper.Wait(ctx, key, n)
+}
+
+type overrideConcurrency struct {
+	interfaces.ConcurrencyLimiter
+	overrides *Overrides
+}
+
+// Для лимитера одновременных запросов поддерживается только Exempt
+func ConcurrencyWithOverrides(lim interfaces.ConcurrencyLimiter, overrides *Overrides) interfaces.ConcurrencyLimiter {
+	return &overrideConcurrency{ConcurrencyLimiter: lim, overrides: overrides}
+}
+
+func (l *overrideConcurrency) Acquire(ctx context.Context, key string) (func() error, bool, error) {
+	if _, ok := l.overrides.entries.get(key); ok {
+		return func() error { return nil }, true, nil
+	}
+	return l.ConcurrencyLimiter.Acquire(ctx, key)
+}
@@ -0,0 +1,82 @@
+package limiter_test
+
+import (
+	"context"
+	"fmt"
+	"gateway/internal/limiter"
+	"gateway/internal/storages"
+	"gateway/server/interfaces"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
+// Экземпляры шлюза одновременно меняют исключения в общем Redis
+// и синхронизируются: ни одно изменение не теряется
+func TestOverridesConcurrentInstances(t *testing.T) {
+	const (
+		instances = 4
+		perInst   = 10
+	)
+
+	mr, rdb := newTestRedis(t)
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+
+	overrides := make([]*limiter.Overrides, instances)
+	for i := range overrides {
+		// режим lock: блокировка не защищает от других экземпляров
+		stor := storages.NewRedisStorage(rdb, time.Minute)
+		overrides[i] = limiter.NewOverrides("test", stor, nil, time.Hour)
+	}
+
+	var wg sync.WaitGroup
+	for i, o := range overrides {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range perInst {
+				key := fmt.Sprintf("client-%d-%d", i, j)
+				if err := o.Set(ctx, key, interfaces.LimitOverride{Exempt: true, Expires: expires}); err != nil {
+					t.Errorf("Set() error = %v", err)
+				}
+				// синхронизация другого экземпляра не перезаписывает запись
+				if _, err := overrides[(i+1)%instances].List(ctx); err != nil {
+					t.Errorf("List() error = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, o := range overrides {
+		entries, err := o.List(ctx)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(entries) != instances*perInst {
+			t.Errorf("instance %d: overrides = %d, want %d", i, len(entries), instances*perInst)
+		}
+	}
+
+	// запись хранится без срока, ее не удаляет keyTTL хранилища
+	mr.FastForward(time.Hour)
+	entries, err := overrides[0].List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != instances*perInst {
+		t.Errorf("overrides after key ttl = %d, want %d", len(entries), instances*perInst)
+	}
+}
@@ -0,0 +1,57 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"gateway/server/interfaces"
+	"sync/atomic"
+)
+
+type reservingLimiter struct {
+	*limiter
+	alg RefundAlgorithm
+}
+
+func (l *reservingLimiter) Reserve(ctx context.Context, key string, n int) (interfaces.Decision, interfaces.Reservation, error) {
+	d, err := l.AllowN(ctx, key, n)
+	if err != nil || !d.Allowed {
+		return d, nil, err
+	}
+	return d, &reservation{l: l, key: key, n: n, decision: d}, nil
+}
+
+type reservation struct {
+	l        *reservingLimiter
+	key      string
+	n        int
+	decision interfaces.Decision
+	done     atomic.Bool
+}
+
+func (r *reservation) Commit() { r.done.Store(true) }
+
+func (r *reservation) Cancel(ctx context.Context) error {
+	if !r.done.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	fact := r.l.facade
+	err := r.l.stor.Update(ctx, UpdateInput{r.key, fact.name, fact.unmarsh}, func(s *State) (*State, error) {
+		// состояние истекло - возвращать нечего
+		if s == nil {
+			return fact.FirstState(), nil
+		}
+		return r.l.alg.Refund(s, r.n, r.decision)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot refund: %w", err)
+	}
+	return nil
+}
+
+// Резервирование, которое нечего возвращать: например, квота по исключению
+type noopReservation struct{}
+
+func (noopReservation) Commit() {}
+
+func (noopReservation) Cancel(context.Context) error { return nil }
@@ -0,0 +1,63 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"gateway/server/interfaces"
+	"time"
+)
+
+type shaper struct {
+	*limiter
+	alg ShapingAlgorithm
+	// nil - алгоритм не возвращает место в очереди
+	refund *reservingLimiter
+}
+
+func NewShaper(facade *AlgorithmFacade, stor Storage) (interfaces.Shaper, error) {
+	alg, ok := facade.Algorithm.(ShapingAlgorithm)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrShapingNotSupported, facade.name)
+	}
+	s := &shaper{
+		limiter: &limiter{facade: facade, stor: stor},
+		alg:     alg,
+	}
+	if refund, ok := facade.Algorithm.(RefundAlgorithm); ok {
+		s.refund = &reservingLimiter{limiter: s.limiter, alg: refund}
+	}
+	return s, nil
+}
+
+func (s *shaper) Wait(
+	ctx context.Context, key string, n int,
+) (time.Time, interfaces.Decision, interfaces.Reservation, error) {
+	input := UpdateInput{key, s.facade.name, s.facade.unmarsh}
+
+	var (
+		until    time.Time
+		decision interfaces.Decision
+	)
+	err := s.stor.Update(
+		ctx,
+		input,
+		func(st *State) (new *State, err error) {
+			if st == nil {
+				st = s.facade.FirstState()
+			}
+			until, decision, new, err = s.alg.Schedule(st, n)
+			return new, err
+		},
+	)
+	if err != nil {
+		return time.Time{}, interfaces.Decision{}, nil, fmt.Errorf("cannot update state: %w", err)
+	}
+
+	switch {
+	case !decision.Allowed:
+		return until, decision, nil, nil
+	case s.refund == nil:
+		return until, decision, noopReservation{}, nil
+	}
+	return until, decision, &reservation{l: s.refund, key: key, n: n, decision: decision}, nil
+}
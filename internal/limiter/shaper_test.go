@@ -0,0 +1,64 @@
+package limiter_test
+
+import (
+	"context"
+	"gateway/internal/algorithm"
+	"gateway/internal/algorithm/leakybucket"
+	"gateway/internal/clock"
+	"gateway/internal/limiter"
+	"gateway/internal/storages"
+	"testing"
+	"time"
+)
+
+// Запрос, не дождавшийся очереди, освобождает свое место
+func TestShaperCancelReleasesSlot(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	clk := clock.NewFake(start)
+	shaper, err := limiter.NewShaper(
+		limiter.NewFacade(
+			"leaky_bucket",
+			leakybucket.NewLeakyBucket(10, 200*time.Millisecond, clk),
+			algorithm.NewStateUnmarshaler[leakybucket.Params](),
+		),
+		storages.NewMemoryStorage(0, 0, 0),
+	)
+	if err != nil {
+		t.Fatalf("NewShaper() error = %v", err)
+	}
+
+	ctx := context.Background()
+	wait := func() time.Time {
+		t.Helper()
+		until, d, res, err := shaper.Wait(ctx, "key", 1)
+		if err != nil || !d.Allowed || res == nil {
+			t.Fatalf("Wait() = %v, %v, %v, want allowed with reservation", d, res, err)
+		}
+		return until
+	}
+
+	wait()
+	until, _, res, err := shaper.Wait(ctx, "key", 1)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if err = res.Cancel(ctx); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if got := wait(); !got.Equal(until) {
+		t.Errorf("until = %v, want released slot %v", got, until)
+	}
+
+	// подтвержденное место не возвращается
+	_, _, res, err = shaper.Wait(ctx, "key", 1)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	res.Commit()
+	if err = res.Cancel(ctx); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	if _, d, _, _ := shaper.Wait(ctx, "key", 1); d.Allowed {
+		t.Errorf("allowed = true, want queue full after committed slot")
+	}
+}
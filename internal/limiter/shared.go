@@ -0,0 +1,167 @@
+package limiter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+type sharedMapParams[V any] struct {
+	Entries map[string]V
+}
+
+func (p *sharedMapParams[V]) Marshal() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+type sharedMapUnmarshaler[V any] struct{}
+
+func (sharedMapUnmarshaler[V]) Unmarshal(data []byte) (*State, error) {
+	var p sharedMapParams[V]
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidState, err)
+	}
+	return &State{Params: &p}, nil
+}
+
+// Значения по ключам одной записью хранилища, общей для экземпляров шлюза.
+// Экземпляр только читает запись раз в syncInterval и проверяет запросы
+// по своей копии, а меняет ее атомарно через UpdateShared. Запись хранится
+// без срока, истекшие значения удаляются при изменении
+type sharedMap[V any] struct {
+	stor    Storage
+	input   UpdateInput
+	expires func(V) time.Time
+
+	mu      sync.RWMutex
+	entries map[string]V
+	// номер изменения: копия, прочитанная до изменения этим экземпляром, устарела
+	version uint64
+
+	stop chan struct{}
+	once sync.Once
+}
+
+func newSharedMap[V any](
+	name, algorithm string, stor Storage, expires func(V) time.Time, syncInterval time.Duration,
+) *sharedMap[V] {
+	m := &sharedMap[V]{
+		stor:    stor,
+		input:   UpdateInput{name, algorithm, sharedMapUnmarshaler[V]{}},
+		expires: expires,
+		entries: make(map[string]V),
+		stop:    make(chan struct{}),
+	}
+	go m.syncLoop(syncInterval)
+	return m
+}
+
+// Проверка по локальной копии, без обращения к хранилищу
+func (m *sharedMap[V]) get(key string) (V, bool) {
+	m.mu.RLock()
+	v, ok := m.entries[key]
+	m.mu.RUnlock()
+
+	if !ok || !time.Now().Before(m.expires(v)) {
+		var zero V
+		return zero, false
+	}
+	return v, true
+}
+
+func (m *sharedMap[V]) list(ctx context.Context) (map[string]V, error) {
+	if err := m.sync(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return maps.Clone(m.entries), nil
+}
+
+func (m *sharedMap[V]) modify(ctx context.Context, change func(map[string]V)) error {
+	var entries map[string]V
+	err := m.stor.UpdateShared(ctx, m.input, 0, func(s *State) (*State, error) {
+		var err error
+		if entries, err = m.decode(s); err != nil {
+			return nil, err
+		}
+		change(entries)
+		m.removeExpired(entries)
+		return &State{Params: &sharedMapParams[V]{Entries: entries}}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.entries = entries
+	m.version++
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *sharedMap[V]) sync(ctx context.Context) error {
+	m.mu.RLock()
+	version := m.version
+	m.mu.RUnlock()
+
+	s, err := m.stor.GetShared(ctx, m.input)
+	if err != nil {
+		return err
+	}
+	entries, err := m.decode(s)
+	if err != nil {
+		return err
+	}
+
+	m.removeExpired(entries)
+	m.mu.Lock()
+	if m.version == version {
+		m.entries = entries
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *sharedMap[V]) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			// при ошибке запросы проверяются по последней прочитанной копии
+			m.sync(context.Background())
+		}
+	}
+}
+
+func (m *sharedMap[V]) close() {
+	m.once.Do(func() { close(m.stop) })
+}
+
+func (m *sharedMap[V]) decode(s *State) (map[string]V, error) {
+	entries := make(map[string]V)
+	if s == nil {
+		return entries, nil
+	}
+	p, ok := s.Params.(*sharedMapParams[V])
+	if !ok {
+		return nil, ErrInvalidState
+	}
+	maps.Copy(entries, p.Entries)
+	return entries, nil
+}
+
+func (m *sharedMap[V]) removeExpired(entries map[string]V) {
+	now := time.Now()
+	maps.DeleteFunc(entries, func(_ string, v V) bool {
+		return !now.Before(m.expires(v))
+	})
+}
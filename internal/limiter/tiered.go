@@ -0,0 +1,82 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"gateway/server/interfaces"
+)
+
+type Tier struct {
+	Name   string
+	Facade *AlgorithmFacade
+}
+
+type tieredLimiter struct {
+	tiers []Tier
+	stor  Storage
+}
+
+// Запрос разрешен, только если его разрешают все уровни.
+// Если хотя бы один уровень отклонил запрос, квота остальных не расходуется
+func NewTieredLimiter(tiers []Tier, stor Storage) interfaces.Limiter {
+	return &tieredLimiter{tiers: tiers, stor: stor}
+}
+
+func (l *tieredLimiter) Allow(ctx context.Context, key string) (interfaces.Decision, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+func (l *tieredLimiter) AllowN(ctx context.Context, key string, n int) (interfaces.Decision, error) {
+	inputs := make([]UpdateInput, len(l.tiers))
+	for i, t := range l.tiers {
+		// у уровней может быть один алгоритм, поэтому состояние отделяется именем уровня
+		inputs[i] = UpdateInput{key, t.Facade.name + ":" + t.Name, t.Facade.unmarsh}
+	}
+
+	var decision interfaces.Decision
+	err := l.stor.UpdateMulti(
+		ctx,
+		inputs,
+		func(states []*State) ([]*State, error) {
+			newStates := make([]*State, len(states))
+			for i, t := range l.tiers {
+				s := states[i]
+				if s == nil {
+					s = t.Facade.FirstState()
+				}
+
+				d, new, err := t.Facade.Action(s, n)
+				if err != nil {
+					return nil, err
+				}
+				d.Tier = t.Name
+
+				if !d.Allowed {
+					// квота разрешивших уровней не расходуется, а состояние
+					// отклонившего записывается - он ничего не списал
+					decision = d
+					clear(newStates)
+					newStates[i] = new
+					return newStates, nil
+				}
+				if i == 0 || moreRestrictive(d, decision) {
+					decision = d
+				}
+				newStates[i] = new
+			}
+			return newStates, nil
+		},
+	)
+	if err != nil {
+		return interfaces.Decision{}, fmt.Errorf("cannot update states: %w", err)
+	}
+	return decision, nil
+}
+
+// Уровень, на котором квота закончится раньше
+func moreRestrictive(d, than interfaces.Decision) bool {
+	if than.Limit == 0 {
+		return d.Limit != 0
+	}
+	return d.Limit != 0 && d.Remaining < than.Remaining
+}
@@ -7,9 +7,15 @@ import (
 )
 
 var (
-	limiterLabels = []string{"allowed", "dest"}
-	proxyLabels   = []string{"dest"}
-	cacheLabels   = []string{"host", "path", "query", "hit"}
+	limiterLabels  = []string{"allowed", "dest", "tier"}
+	queueLabels    = []string{"dest", "cancelled"}
+	degradedLabels = []string{"dest", "policy"}
+	shadowLabels   = []string{"would_reject", "dest", "tier"}
+	limitLabels    = []string{"dest"}
+	banLabels      = []string{"dest", "event"}
+	denyLabels     = []string{"hit"}
+	proxyLabels    = []string{"dest"}
+	cacheLabels    = []string{"host", "path", "query", "hit"}
 )
 
 type metric struct {
@@ -52,8 +58,101 @@ func NewLimiterMetric(name string) *limiterMetric {
 	}
 }
 
-func (m *limiterMetric) Inc(allow bool, dest string) {
-	m.metric.valuesChan <- []string{strconv.FormatBool(allow), dest}
+func (m *limiterMetric) Inc(allow bool, dest, tier string) {
+	m.metric.valuesChan <- []string{strconv.FormatBool(allow), dest, tier}
+}
+
+type banMetric struct {
+	*metric
+}
+
+func NewBanMetric(name string) *banMetric {
+	return &banMetric{
+		metric: newMetric(name, banLabels),
+	}
+}
+
+func (m *banMetric) Inc(dest, event string) {
+	m.metric.valuesChan <- []string{dest, event}
+}
+
+type denyCacheMetric struct {
+	*metric
+}
+
+func NewDenyCacheMetric(name string) *denyCacheMetric {
+	return &denyCacheMetric{
+		metric: newMetric(name, denyLabels),
+	}
+}
+
+func (m *denyCacheMetric) Inc(hit bool) {
+	m.metric.valuesChan <- []string{strconv.FormatBool(hit)}
+}
+
+type queueMetric struct {
+	*metric
+}
+
+func NewQueueMetric(name string) *queueMetric {
+	return &queueMetric{
+		metric: newMetric(name, queueLabels),
+	}
+}
+
+func (m *queueMetric) Inc(dest string, cancelled bool) {
+	m.metric.valuesChan <- []string{dest, strconv.FormatBool(cancelled)}
+}
+
+type shadowMetric struct {
+	*metric
+}
+
+func NewShadowMetric(name string) *shadowMetric {
+	return &shadowMetric{
+		metric: newMetric(name, shadowLabels),
+	}
+}
+
+func (m *shadowMetric) Inc(wouldReject bool, dest, tier string) {
+	m.metric.valuesChan <- []string{strconv.FormatBool(wouldReject), dest, tier}
+}
+
+type degradedMetric struct {
+	*metric
+}
+
+func NewDegradedMetric(name string) *degradedMetric {
+	return &degradedMetric{
+		metric: newMetric(name, degradedLabels),
+	}
+}
+
+func (m *degradedMetric) Inc(dest, policy string) {
+	m.metric.valuesChan <- []string{dest, policy}
+}
+
+type limitMetric struct {
+	gauge *prometheus.GaugeVec
+}
+
+func NewLimitMetric(name string) *limitMetric {
+	return &limitMetric{
+		gauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: name,
+			},
+			limitLabels,
+		),
+	}
+}
+
+func (m *limitMetric) Register() error {
+	return prometheus.Register(m.gauge)
+}
+
+func (m *limitMetric) Set(dest string, limit float64) {
+	m.gauge.WithLabelValues(dest).Set(limit)
 }
 
 type proxyMetric struct {
@@ -0,0 +1,250 @@
+package storages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	lim "gateway/internal/limiter"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Блокировка - время окончания в микросекундах, счетчик отказов - число
+func banKey(name, key string) string     { return fmt.Sprintf("bans:%s:%s", name, key) }
+func strikesKey(name, key string) string { return fmt.Sprintf("strikes:%s:%s", name, key) }
+
+func encodeBan(until time.Time) string {
+	return strconv.FormatInt(until.UnixMicro(), 10)
+}
+
+func decodeBan(val string) (time.Time, error) {
+	us, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid ban %q", lim.ErrInvalidState, val)
+	}
+	return time.UnixMicro(us), nil
+}
+
+// Отказы всех ключей отправляются одним конвейером: INCRBY, EXPIRE NX
+// начинает окно с первого отказа, GET читает блокировку
+func (s *redisStorage) AddStrikes(
+	ctx context.Context, name string, strikes map[string]int, window time.Duration,
+) (map[string]int, map[string]time.Time, error) {
+	incrs := make(map[string]*redis.IntCmd, len(strikes))
+	gets := make(map[string]*redis.StringCmd, len(strikes))
+	_, err := s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, n := range strikes {
+			incrs[key] = pipe.IncrBy(ctx, strikesKey(name, key), int64(n))
+			pipe.ExpireNX(ctx, strikesKey(name, key), window)
+			gets[key] = pipe.Get(ctx, banKey(name, key))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, err
+	}
+
+	counts := make(map[string]int, len(strikes))
+	bans := make(map[string]time.Time)
+	for key, cmd := range incrs {
+		counts[key] = int(cmd.Val())
+		val, err := gets[key].Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if bans[key], err = decodeBan(val); err != nil {
+			return nil, nil, err
+		}
+	}
+	return counts, bans, nil
+}
+
+func (s *redisStorage) Ban(ctx context.Context, name, key string, until time.Time, ttl time.Duration) (bool, error) {
+	var set *redis.BoolCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		set = pipe.SetNX(ctx, banKey(name, key), encodeBan(until), ttl)
+		pipe.Del(ctx, strikesKey(name, key))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return set.Val(), nil
+}
+
+func (s *redisStorage) Bans(ctx context.Context, name string, keys []string) (map[string]time.Time, error) {
+	if keys == nil {
+		var err error
+		if keys, err = s.bannedKeys(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	bans := make(map[string]time.Time)
+	if len(keys) == 0 {
+		return bans, nil
+	}
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		redisKeys[i] = banKey(name, key)
+	}
+	values, err := s.rdb.MGet(ctx, redisKeys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		// блокировка истекла после SCAN
+		val, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if bans[keys[i]], err = decodeBan(val); err != nil {
+			return nil, err
+		}
+	}
+	return bans, nil
+}
+
+func (s *redisStorage) bannedKeys(ctx context.Context, name string) ([]string, error) {
+	prefix := banKey(name, "")
+	var (
+		keys   []string
+		cursor uint64
+	)
+	for {
+		batch, next, err := s.rdb.Scan(ctx, cursor, escapeGlob(prefix)+"*", scanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range batch {
+			keys = append(keys, strings.TrimPrefix(key, prefix))
+		}
+		if cursor = next; cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+func (s *redisStorage) Unban(ctx context.Context, name, key string) error {
+	return s.rdb.Del(ctx, banKey(name, key), strikesKey(name, key)).Err()
+}
+
+// В памяти блокировки и отказы - обычные записи шардов со сроком
+func (s *memoryStorage) AddStrikes(
+	ctx context.Context, name string, strikes map[string]int, window time.Duration,
+) (map[string]int, map[string]time.Time, error) {
+	counts := make(map[string]int, len(strikes))
+	bans := make(map[string]time.Time)
+	for key, n := range strikes {
+		count, err := s.addStrikes(strikesKey(name, key), n, window)
+		if err != nil {
+			return nil, nil, err
+		}
+		counts[key] = count
+
+		until, ok, err := s.ban(banKey(name, key))
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			bans[key] = until
+		}
+	}
+	return counts, bans, nil
+}
+
+func (s *memoryStorage) addStrikes(key string, n int, window time.Duration) (int, error) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := time.Now()
+	count, expireAt := 0, now.Add(window)
+	if e, ok := sh.get(key, now); ok {
+		var err error
+		if count, err = strconv.Atoi(string(e.data)); err != nil {
+			return 0, fmt.Errorf("%w: invalid strikes %q", lim.ErrInvalidState, e.data)
+		}
+		expireAt = e.expireAt
+	}
+	count += n
+	sh.set(key, []byte(strconv.Itoa(count)), expireAt)
+	return count, nil
+}
+
+func (s *memoryStorage) ban(key string) (time.Time, bool, error) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := sh.get(key, time.Now())
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	until, err := decodeBan(string(e.data))
+	return until, err == nil, err
+}
+
+func (s *memoryStorage) Ban(ctx context.Context, name, key string, until time.Time, ttl time.Duration) (bool, error) {
+	s.delete(strikesKey(name, key))
+
+	bk := banKey(name, key)
+	sh := s.shard(bk)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := sh.get(bk, now); ok {
+		return false, nil
+	}
+	sh.set(bk, []byte(encodeBan(until)), now.Add(ttl))
+	return true, nil
+}
+
+func (s *memoryStorage) Bans(ctx context.Context, name string, keys []string) (map[string]time.Time, error) {
+	bans := make(map[string]time.Time)
+	if keys != nil {
+		for _, key := range keys {
+			until, ok, err := s.ban(banKey(name, key))
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				bans[key] = until
+			}
+		}
+		return bans, nil
+	}
+
+	prefix := banKey(name, "")
+	now := time.Now()
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for key, el := range sh.entries {
+			e := el.Value.(*memoryEntry)
+			if e.expired(now) || !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			until, err := decodeBan(string(e.data))
+			if err != nil {
+				sh.mu.Unlock()
+				return nil, err
+			}
+			bans[strings.TrimPrefix(key, prefix)] = until
+		}
+		sh.mu.Unlock()
+	}
+	return bans, nil
+}
+
+func (s *memoryStorage) Unban(ctx context.Context, name, key string) error {
+	s.delete(banKey(name, key))
+	s.delete(strikesKey(name, key))
+	return nil
+}
@@ -0,0 +1,72 @@
+package storages
+
+import (
+	"context"
+	lim "gateway/internal/limiter"
+	"testing"
+	"time"
+)
+
+func TestBanStorage(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	tests := []struct {
+		name string
+		stor lim.BanStorage
+	}{
+		{name: "redis", stor: NewRedisStorage(rdb, 0)},
+		{name: "memory", stor: NewMemoryStorage(0, 0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			until := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+
+			counts, bans, err := tt.stor.AddStrikes(ctx, "edge", map[string]int{"a": 2, "b": 1}, time.Minute)
+			if err != nil {
+				t.Fatalf("AddStrikes() error = %v", err)
+			}
+			if counts["a"] != 2 || counts["b"] != 1 || len(bans) != 0 {
+				t.Errorf("AddStrikes() = %v, %v, want a=2 b=1 without bans", counts, bans)
+			}
+			if counts, _, _ = tt.stor.AddStrikes(ctx, "edge", map[string]int{"a": 1}, time.Minute); counts["a"] != 3 {
+				t.Errorf("strikes of a = %d, want 3", counts["a"])
+			}
+
+			ok, err := tt.stor.Ban(ctx, "edge", "a", until, time.Hour)
+			if err != nil || !ok {
+				t.Fatalf("Ban() = %v, %v, want true", ok, err)
+			}
+			if ok, _ = tt.stor.Ban(ctx, "edge", "a", until.Add(time.Hour), time.Hour); ok {
+				t.Error("second Ban() replaced existing ban")
+			}
+
+			// блокировка сбросила отказы
+			counts, bans, err = tt.stor.AddStrikes(ctx, "edge", map[string]int{"a": 1}, time.Minute)
+			if err != nil {
+				t.Fatalf("AddStrikes() error = %v", err)
+			}
+			if counts["a"] != 1 || !bans["a"].Equal(until) {
+				t.Errorf("AddStrikes() = %v, %v, want a=1 banned until %v", counts, bans, until)
+			}
+
+			all, err := tt.stor.Bans(ctx, "edge", nil)
+			if err != nil || len(all) != 1 || !all["a"].Equal(until) {
+				t.Errorf("Bans(nil) = %v, %v, want a only", all, err)
+			}
+			if other, _ := tt.stor.Bans(ctx, "internal", nil); len(other) != 0 {
+				t.Errorf("bans of other limiter = %v, want none", other)
+			}
+			if some, _ := tt.stor.Bans(ctx, "edge", []string{"a", "b"}); len(some) != 1 {
+				t.Errorf("Bans(a, b) = %v, want a only", some)
+			}
+
+			if err = tt.stor.Unban(ctx, "edge", "a"); err != nil {
+				t.Fatalf("Unban() error = %v", err)
+			}
+			if all, _ = tt.stor.Bans(ctx, "edge", nil); len(all) != 0 {
+				t.Errorf("bans after Unban = %v, want none", all)
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package storages
+
+import (
+	"context"
+	"errors"
+	lim "gateway/internal/limiter"
+	"gateway/server/interfaces"
+	"sync"
+	"time"
+)
+
+const localPolicy = "local"
+
+type breakerStorage struct {
+	primary lim.Storage
+	// nil - пока выключатель разомкнут, обновления завершаются ErrCircuitOpen
+	fallback lim.Storage
+	// может быть nil
+	metric interfaces.DegradedMetric
+
+	failures int
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	failed    int
+	open      bool
+	openUntil time.Time
+	// после cooldown primary проверяет одно обращение, остальные ждут его результата
+	probing bool
+}
+
+// Автоматический выключатель вокруг хранилища: после failures ошибок подряд
+// primary не опрашивается cooldown, чтобы не ждать таймаута на каждом запросе.
+// Затем в primary идет одно пробное обновление, остальные до его завершения
+// обслуживаются как при разомкнутом выключателе. Если проба удалась, выключатель
+// замыкается, иначе снова размыкается на cooldown
+func NewBreakerStorage(
+	primary, fallback lim.Storage,
+	failures int, cooldown time.Duration,
+	metric interfaces.DegradedMetric,
+) *breakerStorage {
+	return &breakerStorage{
+		primary:  primary,
+		fallback: fallback,
+		metric:   metric,
+		failures: failures,
+		cooldown: cooldown,
+	}
+}
+
+func (s *breakerStorage) Update(ctx context.Context, input lim.UpdateInput, update lim.UpdateFunc) error {
+	if ok, probe := s.acquire(); ok {
+		err := s.primary.Update(ctx, input, update)
+		if !s.record(err, probe) || s.fallback == nil {
+			return err
+		}
+	} else if s.fallback == nil {
+		return ErrCircuitOpen
+	}
+
+	s.degraded(input.Key)
+	return s.fallback.Update(ctx, input, update)
+}
+
+func (s *breakerStorage) UpdateMulti(ctx context.Context, inputs []lim.UpdateInput, update lim.MultiUpdateFunc) error {
+	if ok, probe := s.acquire(); ok {
+		err := s.primary.UpdateMulti(ctx, inputs, update)
+		if !s.record(err, probe) || s.fallback == nil {
+			return err
+		}
+	} else if s.fallback == nil {
+		return ErrCircuitOpen
+	}
+
+	if len(inputs) > 0 {
+		s.degraded(inputs[0].Key)
+	}
+	return s.fallback.UpdateMulti(ctx, inputs, update)
+}
+
+// Если primary не ведет журнал сам, журнал обновляется как обычное состояние
+func (s *breakerStorage) UpdateLog(
+	ctx context.Context, input lim.UpdateInput, req lim.LogRequest, update lim.UpdateFunc,
+) (lim.LogResult, bool, error) {
+	primary, ok := s.primary.(lim.LogStorage)
+	if !ok {
+		return lim.LogResult{}, false, s.Update(ctx, input, update)
+	}
+
+	if ok, probe := s.acquire(); ok {
+		res, native, err := primary.UpdateLog(ctx, input, req, update)
+		if !s.record(err, probe) || s.fallback == nil {
+			return res, native, err
+		}
+	} else if s.fallback == nil {
+		return lim.LogResult{}, false, ErrCircuitOpen
+	}
+
+	s.degraded(input.Key)
+	return lim.LogResult{}, false, s.fallback.Update(ctx, input, update)
+}
+
+func (s *breakerStorage) Get(ctx context.Context, input lim.UpdateInput) (*lim.State, error) {
+	stor, err := s.available()
+	if err != nil {
+		return nil, err
+	}
+	return stor.Get(ctx, input)
+}
+
+func (s *breakerStorage) Delete(ctx context.Context, input lim.UpdateInput) error {
+	stor, err := s.available()
+	if err != nil {
+		return err
+	}
+	return stor.Delete(ctx, input)
+}
+
+func (s *breakerStorage) Keys(ctx context.Context, algorithm, prefix string, limit int) ([]string, error) {
+	stor, err := s.available()
+	if err != nil {
+		return nil, err
+	}
+	return stor.Keys(ctx, algorithm, prefix, limit)
+}
+
+// Общие состояния не уходят в резервное хранилище: его копия разошлась бы
+// с остальными экземплярами шлюза и заменила бы их данные после восстановления
+func (s *breakerStorage) GetShared(ctx context.Context, input lim.UpdateInput) (*lim.State, error) {
+	if !s.closed() {
+		return nil, ErrCircuitOpen
+	}
+	return s.primary.GetShared(ctx, input)
+}
+
+func (s *breakerStorage) UpdateShared(
+	ctx context.Context, input lim.UpdateInput, ttl time.Duration, update lim.UpdateFunc,
+) error {
+	if !s.closed() {
+		return ErrCircuitOpen
+	}
+	return s.primary.UpdateShared(ctx, input, ttl, update)
+}
+
+func (s *breakerStorage) AddStrikes(
+	ctx context.Context, name string, strikes map[string]int, window time.Duration,
+) (map[string]int, map[string]time.Time, error) {
+	stor, err := s.banStorage()
+	if err != nil {
+		return nil, nil, err
+	}
+	return stor.AddStrikes(ctx, name, strikes, window)
+}
+
+func (s *breakerStorage) Ban(ctx context.Context, name, key string, until time.Time, ttl time.Duration) (bool, error) {
+	stor, err := s.banStorage()
+	if err != nil {
+		return false, err
+	}
+	return stor.Ban(ctx, name, key, until, ttl)
+}
+
+func (s *breakerStorage) Bans(ctx context.Context, name string, keys []string) (map[string]time.Time, error) {
+	stor, err := s.banStorage()
+	if err != nil {
+		return nil, err
+	}
+	return stor.Bans(ctx, name, keys)
+}
+
+func (s *breakerStorage) Unban(ctx context.Context, name, key string) error {
+	stor, err := s.banStorage()
+	if err != nil {
+		return err
+	}
+	return stor.Unban(ctx, name, key)
+}
+
+// Блокировки, как и общие состояния, не уходят в резервное хранилище
+func (s *breakerStorage) banStorage() (lim.BanStorage, error) {
+	stor, ok := s.primary.(lim.BanStorage)
+	if !ok {
+		return nil, ErrBansNotSupported
+	}
+	if !s.closed() {
+		return nil, ErrCircuitOpen
+	}
+	return stor, nil
+}
+
+// Хранилище для чтения и удаления состояний. Ошибки этих обращений
+// не учитываются выключателем - они не выполняются на каждом запросе
+func (s *breakerStorage) available() (lim.Storage, error) {
+	if s.closed() {
+		return s.primary, nil
+	}
+	if s.fallback == nil {
+		return nil, ErrCircuitOpen
+	}
+	return s.fallback, nil
+}
+
+// Обращения, которые не учитываются выключателем, идут в primary,
+// только пока он замкнут: пробой считается лишь обновление
+func (s *breakerStorage) closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.open
+}
+
+// Можно ли обновлять primary, probe - обновление пробное.
+// Результат обновления передается в record
+func (s *breakerStorage) acquire() (ok, probe bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case !s.open:
+		return true, false
+	case s.probing || time.Now().Before(s.openUntil):
+		return false, false
+	}
+	s.probing = true
+	return true, true
+}
+
+// Учитывает результат обращения к primary, true - хранилище недоступно
+func (s *breakerStorage) record(err error, probe bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if probe {
+		s.probing = false
+		// отмена запроса клиентом ничего не говорит о primary: пробует следующее обновление
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+	}
+	if !unavailable(err) {
+		s.failed, s.open = 0, false
+		return false
+	}
+
+	s.failed++
+	if probe || s.failed >= s.failures {
+		s.open, s.openUntil = true, time.Now().Add(s.cooldown)
+	}
+	return true
+}
+
+// Останавливает очистку резервного хранилища
+func (s *breakerStorage) Close() {
+	if c, ok := s.fallback.(interface{ Close() }); ok {
+		c.Close()
+	}
+}
+
+func (s *breakerStorage) degraded(key string) {
+	if s.metric != nil {
+		s.metric.Inc(key, localPolicy)
+	}
+}
+
+// Ошибки состояния, конфликты и отмена запроса клиентом не говорят о недоступности хранилища
+func unavailable(err error) bool {
+	return err != nil &&
+		!errors.Is(err, ErrTooManyRetries) &&
+		!errors.Is(err, lim.ErrInvalidState) &&
+		!errors.Is(err, context.Canceled) &&
+		!isWrongType(err)
+}
@@ -0,0 +1,185 @@
+package storages
+
+import (
+	"context"
+	"errors"
+	"gateway/internal/algorithm"
+	"gateway/internal/algorithm/fixedwindow"
+	"gateway/internal/limiter"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errUnavailable = errors.New("connection refused")
+
+// Хранилище, которое по запросу отвечает ошибкой или ждет release
+type flakyStorage struct {
+	limiter.Storage
+	fail  atomic.Bool
+	calls atomic.Int32
+	// не nil - Update ждет, пока канал не закроют
+	release chan struct{}
+	entered chan struct{}
+}
+
+func (s *flakyStorage) Update(ctx context.Context, input limiter.UpdateInput, update limiter.UpdateFunc) error {
+	s.calls.Add(1)
+	if s.release != nil {
+		s.entered <- struct{}{}
+		<-s.release
+	}
+	if s.fail.Load() {
+		return errUnavailable
+	}
+	return s.Storage.Update(ctx, input, update)
+}
+
+type degradedCounter struct {
+	n atomic.Int32
+}
+
+func (c *degradedCounter) Inc(key, policy string) { c.n.Add(1) }
+
+func breakerUpdate(stor limiter.Storage) error {
+	input := limiter.UpdateInput{
+		Key:       "client",
+		Algorithm: "fixed_window",
+		Unmarsh:   algorithm.NewStateUnmarshaler[fixedwindow.Params](),
+	}
+	return stor.Update(context.Background(), input, func(*limiter.State) (*limiter.State, error) {
+		return &limiter.State{Params: &fixedwindow.Params{Count: 1}}, nil
+	})
+}
+
+func TestBreakerStorageTransitions(t *testing.T) {
+	const cooldown = 30 * time.Millisecond
+
+	t.Run("opens after failures", func(t *testing.T) {
+		primary := &flakyStorage{Storage: NewMemoryStorage(0, 0, 0)}
+		stor := NewBreakerStorage(primary, nil, 2, time.Minute, nil)
+
+		primary.fail.Store(true)
+		for range 2 {
+			if err := breakerUpdate(stor); !errors.Is(err, errUnavailable) {
+				t.Fatalf("Update() error = %v, want primary error", err)
+			}
+		}
+		if err := breakerUpdate(stor); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Update() error = %v, want ErrCircuitOpen", err)
+		}
+		if got := primary.calls.Load(); got != 2 {
+			t.Errorf("primary calls = %d, want 2", got)
+		}
+	})
+
+	t.Run("success resets failures", func(t *testing.T) {
+		primary := &flakyStorage{Storage: NewMemoryStorage(0, 0, 0)}
+		stor := NewBreakerStorage(primary, nil, 2, time.Minute, nil)
+
+		primary.fail.Store(true)
+		_ = breakerUpdate(stor)
+		primary.fail.Store(false)
+		_ = breakerUpdate(stor)
+		primary.fail.Store(true)
+		_ = breakerUpdate(stor)
+		if stor.open {
+			t.Error("breaker opened after failures that were not consecutive")
+		}
+	})
+
+	t.Run("local fallback while open", func(t *testing.T) {
+		primary := &flakyStorage{Storage: NewMemoryStorage(0, 0, 0)}
+		metric := &degradedCounter{}
+		stor := NewBreakerStorage(primary, NewMemoryStorage(0, 0, 0), 1, time.Minute, metric)
+
+		primary.fail.Store(true)
+		// обновление, на котором выключатель разомкнулся, уже выполняется резервным хранилищем
+		for range 3 {
+			if err := breakerUpdate(stor); err != nil {
+				t.Fatalf("Update() error = %v, want fallback", err)
+			}
+		}
+		if got := primary.calls.Load(); got != 1 {
+			t.Errorf("primary calls = %d, want 1", got)
+		}
+		if got := metric.n.Load(); got != 3 {
+			t.Errorf("degraded updates = %d, want 3", got)
+		}
+	})
+
+	t.Run("half-open allows one probe", func(t *testing.T) {
+		primary := &flakyStorage{Storage: NewMemoryStorage(0, 0, 0)}
+		stor := NewBreakerStorage(primary, nil, 1, cooldown, nil)
+
+		primary.fail.Store(true)
+		_ = breakerUpdate(stor)
+		time.Sleep(cooldown)
+
+		primary.fail.Store(false)
+		primary.release, primary.entered = make(chan struct{}), make(chan struct{}, 1)
+		probe := make(chan error, 1)
+		go func() { probe <- breakerUpdate(stor) }()
+		<-primary.entered
+
+		// пока проба не завершилась, остальные обновления не идут в primary
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := breakerUpdate(stor); !errors.Is(err, ErrCircuitOpen) {
+					t.Errorf("Update() during probe error = %v, want ErrCircuitOpen", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if got := primary.calls.Load(); got != 2 {
+			t.Fatalf("primary calls = %d, want 2", got)
+		}
+
+		close(primary.release)
+		if err := <-probe; err != nil {
+			t.Fatalf("probe error = %v", err)
+		}
+		primary.release = nil
+		if stor.open {
+			t.Fatal("breaker not closed after successful probe")
+		}
+		if err := breakerUpdate(stor); err != nil {
+			t.Fatalf("Update() after probe error = %v", err)
+		}
+	})
+
+	t.Run("failed probe reopens", func(t *testing.T) {
+		primary := &flakyStorage{Storage: NewMemoryStorage(0, 0, 0)}
+		stor := NewBreakerStorage(primary, nil, 3, cooldown, nil)
+
+		primary.fail.Store(true)
+		for range 3 {
+			_ = breakerUpdate(stor)
+		}
+		time.Sleep(cooldown)
+
+		// одной ошибки пробы достаточно, чтобы снова разомкнуть выключатель
+		if err := breakerUpdate(stor); !errors.Is(err, errUnavailable) {
+			t.Fatalf("probe error = %v, want primary error", err)
+		}
+		if err := breakerUpdate(stor); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Update() after failed probe error = %v, want ErrCircuitOpen", err)
+		}
+		if got := primary.calls.Load(); got != 4 {
+			t.Errorf("primary calls = %d, want 4", got)
+		}
+
+		time.Sleep(cooldown)
+		primary.fail.Store(false)
+		if err := breakerUpdate(stor); err != nil {
+			t.Fatalf("second probe error = %v", err)
+		}
+		if stor.open {
+			t.Error("breaker not closed after successful probe")
+		}
+	})
+}
@@ -0,0 +1,10 @@
+package storages
+
+import "errors"
+
+var (
+	ErrTooManyRetries = errors.New("too many optimistic lock retries")
+	ErrCircuitOpen    = errors.New("storage is unavailable, circuit breaker is open")
+
+	ErrBansNotSupported = errors.New("storage does not support bans")
+)
@@ -0,0 +1,130 @@
+package storages
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	lim "gateway/internal/limiter"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Журнал скользящего окна - ZSET, где score - время запроса в микросекундах.
+// Просроченные запросы удаляются, новые добавляются атомарно на стороне Redis,
+// записей в журнале не больше лимита, ключ истекает вместе с окном.
+// Ключ другого типа (журнал в старом формате - строка JSON) удаляется.
+// Возвращает решение, число запросов в окне, время последнего запроса
+// и для отказа - время записи, после истечения которой квоты хватит
+var slidingLogScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local keyType = redis.call('TYPE', key).ok
+if keyType ~= 'zset' and keyType ~= 'none' then
+	redis.call('DEL', key)
+end
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+
+local allowed = 0
+local release = ''
+if count + cost <= limit then
+	for i = 1, cost do
+		redis.call('ZADD', key, ARGV[1], ARGV[5] .. ':' .. i)
+	end
+	count = count + cost
+	allowed = 1
+elseif cost <= limit then
+	local entry = redis.call('ZRANGE', key, count + cost - limit - 1, count + cost - limit - 1, 'WITHSCORES')
+	release = entry[2] or ''
+end
+
+local newest = ''
+if count > 0 then
+	newest = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')[2]
+	redis.call('PEXPIRE', key, math.ceil(window / 1000))
+end
+return {allowed, count, newest, release}
+`)
+
+func (s *redisStorage) UpdateLog(
+	ctx context.Context, input lim.UpdateInput, req lim.LogRequest, update lim.UpdateFunc,
+) (lim.LogResult, bool, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return lim.LogResult{}, false, err
+	}
+
+	key := s.redisKey(input.Key, input.Algorithm)
+	values, err := slidingLogScript.Run(
+		ctx, s.rdb, []string{key},
+		req.Now.UnixMicro(), req.Window.Microseconds(), req.Limit, req.Cost,
+		strconv.FormatInt(req.Now.UnixMicro(), 10)+":"+hex.EncodeToString(id),
+	).Slice()
+	if err != nil {
+		return lim.LogResult{}, false, err
+	}
+	if len(values) != 4 {
+		return lim.LogResult{}, false, fmt.Errorf("%w: unexpected log script result", lim.ErrInvalidState)
+	}
+
+	allowed, _ := values[0].(int64)
+	count, _ := values[1].(int64)
+	newest, err := scoreTime(values[2])
+	if err != nil {
+		return lim.LogResult{}, false, err
+	}
+	release, err := scoreTime(values[3])
+	if err != nil {
+		return lim.LogResult{}, false, err
+	}
+
+	return lim.LogResult{
+		Allowed: allowed == 1,
+		Count:   int(count),
+		Newest:  newest,
+		Release: release,
+	}, true, nil
+}
+
+// Журнал в виде состояния алгоритма, чтобы его можно было прочитать как обычное
+func (s *redisStorage) getLog(ctx context.Context, key string, unmarsh lim.Unmarshaler[lim.State]) (*lim.State, error) {
+	entries, err := s.rdb.ZRangeWithScores(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	logs := make([]time.Time, len(entries))
+	for i, e := range entries {
+		logs[i] = time.UnixMicro(int64(e.Score))
+	}
+	data, err := json.Marshal(map[string]any{"Logs": logs})
+	if err != nil {
+		return nil, err
+	}
+	return unmarsh.Unmarshal(data)
+}
+
+// Score ZSET в микросекундах, "" - нулевое время
+func scoreTime(v any) (time.Time, error) {
+	str, _ := v.(string)
+	if str == "" {
+		return time.Time{}, nil
+	}
+	us, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid log score %q", lim.ErrInvalidState, str)
+	}
+	return time.UnixMicro(int64(us)), nil
+}
@@ -0,0 +1,51 @@
+package storages
+
+import (
+	"context"
+	"gateway/internal/algorithm/fixedwindow"
+	"gateway/internal/clock"
+	lim "gateway/internal/limiter"
+	"testing"
+	"time"
+)
+
+// Журнал в старом формате - строка JSON под тем же ключом
+func TestRedisStorageLogReplacesOldState(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	stor := NewRedisStorage(rdb, 0)
+
+	input := lim.UpdateInput{Key: "client", Algorithm: "sliding_log"}
+	key := stor.redisKey(input.Key, input.Algorithm)
+	mr.Set(key, `{"Logs":[]}`)
+
+	req := lim.LogRequest{Now: time.Unix(1_700_000_000, 0), Window: time.Minute, Limit: 2, Cost: 1}
+	res, native, err := stor.UpdateLog(context.Background(), input, req, nil)
+	if err != nil {
+		t.Fatalf("UpdateLog() error = %v", err)
+	}
+	if !native || !res.Allowed || res.Count != 1 {
+		t.Errorf("UpdateLog() = %+v, native = %v, want allowed with count 1", res, native)
+	}
+	if typ := mr.Type(key); typ != "zset" {
+		t.Errorf("key type = %q, want zset", typ)
+	}
+}
+
+// Ключ другого типа - ошибка состояния, а не недоступность Redis
+func TestBreakerStorageIgnoresWrongType(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	stor := NewBreakerStorage(NewRedisStorage(rdb, 0), NewMemoryStorage(0, 0, 0), 1, time.Minute, nil)
+
+	if _, err := mr.ZAdd("state:client:fixed_window", 1, "entry"); err != nil {
+		t.Fatalf("ZAdd() error = %v", err)
+	}
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	l := newFixedWindowLimiter(stor, fixedwindow.NewFixedWindow(1, time.Minute, clk))
+
+	if _, err := l.Allow(context.Background(), "client"); !isWrongType(err) {
+		t.Fatalf("Allow() error = %v, want WRONGTYPE", err)
+	}
+	if !stor.closed() {
+		t.Error("breaker opened on WRONGTYPE")
+	}
+}
@@ -0,0 +1,328 @@
+package storages
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	lim "gateway/internal/limiter"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const memoryShardsNum = 32
+
+type memoryEntry struct {
+	key      string
+	data     []byte
+	expireAt time.Time // нулевое - без срока
+}
+
+func (e *memoryEntry) size() int64 { return int64(len(e.key) + len(e.data)) }
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+type memoryShard struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// начало - недавно использованные, конец - кандидаты на вытеснение
+	lru     *list.List
+	used    int64
+	maxUsed int64 // 0 - без ограничения
+}
+
+func newMemoryShard(maxUsed int64) *memoryShard {
+	return &memoryShard{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		maxUsed: maxUsed,
+	}
+}
+
+func (sh *memoryShard) get(key string, now time.Time) (*memoryEntry, bool) {
+	el, ok := sh.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryEntry)
+	if e.expired(now) {
+		sh.remove(el)
+		return nil, false
+	}
+	sh.lru.MoveToFront(el)
+	return e, true
+}
+
+func (sh *memoryShard) set(key string, data []byte, expireAt time.Time) {
+	if el, ok := sh.entries[key]; ok {
+		e := el.Value.(*memoryEntry)
+		sh.used -= e.size()
+		e.data, e.expireAt = data, expireAt
+		sh.used += e.size()
+		sh.lru.MoveToFront(el)
+	} else {
+		e := &memoryEntry{key: key, data: data, expireAt: expireAt}
+		sh.entries[key] = sh.lru.PushFront(e)
+		sh.used += e.size()
+	}
+	sh.evict()
+}
+
+func (sh *memoryShard) remove(el *list.Element) {
+	e := sh.lru.Remove(el).(*memoryEntry)
+	delete(sh.entries, e.key)
+	sh.used -= e.size()
+}
+
+// Вытеснение давно неиспользованных ключей при превышении лимита памяти.
+// Только что записанный ключ не вытесняется
+func (sh *memoryShard) evict() {
+	if sh.maxUsed <= 0 {
+		return
+	}
+	for sh.used > sh.maxUsed && sh.lru.Len() > 1 {
+		sh.remove(sh.lru.Back())
+	}
+}
+
+func (sh *memoryShard) removeExpired(now time.Time) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	for el := sh.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*memoryEntry).expired(now) {
+			sh.remove(el)
+		}
+		el = prev
+	}
+}
+
+type memoryStorage struct {
+	shards [memoryShardsNum]*memoryShard
+	keyTTL time.Duration
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// Хранилище состояний в памяти процесса.
+// maxMemory - суммарный размер ключей и состояний в байтах, 0 - без ограничения.
+// Ограничение делится поровну между шардами с округлением вверх, и ключи
+// вытесняются в пределах своего шарда, поэтому при неравномерном распределении
+// ключей вытеснение может начаться раньше, чем заполнится maxMemory.
+// Просроченные ключи удаляются в фоне раз в cleanupInterval
+func NewMemoryStorage(keyTTL time.Duration, maxMemory int64, cleanupInterval time.Duration) *memoryStorage {
+	s := &memoryStorage{
+		keyTTL: keyTTL,
+		stop:   make(chan struct{}),
+	}
+	// maxMemory меньше числа шардов не должно превращаться в 0 - отсутствие ограничения
+	shardMemory := (maxMemory + memoryShardsNum - 1) / memoryShardsNum
+	for i := range s.shards {
+		s.shards[i] = newMemoryShard(shardMemory)
+	}
+
+	if keyTTL > 0 && cleanupInterval > 0 {
+		go s.cleanup(cleanupInterval)
+	}
+	return s
+}
+
+func (s *memoryStorage) Update(ctx context.Context, input lim.UpdateInput, update lim.UpdateFunc) error {
+	return s.update(input, s.keyTTL, update)
+}
+
+func (s *memoryStorage) GetShared(ctx context.Context, input lim.UpdateInput) (*lim.State, error) {
+	return s.Get(ctx, input)
+}
+
+func (s *memoryStorage) UpdateShared(
+	ctx context.Context, input lim.UpdateInput, ttl time.Duration, update lim.UpdateFunc,
+) error {
+	return s.update(input, ttl, update)
+}
+
+// ttl = 0 - без срока
+func (s *memoryStorage) update(input lim.UpdateInput, ttl time.Duration, update lim.UpdateFunc) error {
+	key := s.memoryKey(input.Key, input.Algorithm)
+	sh := s.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := time.Now()
+
+	var state *lim.State
+	if e, ok := sh.get(key, now); ok {
+		st, err := input.Unmarsh.Unmarshal(e.data)
+		if err != nil {
+			return err
+		}
+		state = st
+	}
+
+	newState, err := update(state)
+	if err != nil {
+		return err
+	}
+
+	data, err := newState.Params.Marshal()
+	if err != nil {
+		return err
+	}
+
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = now.Add(ttl)
+	}
+	sh.set(key, data, expireAt)
+	return nil
+}
+
+func (s *memoryStorage) UpdateMulti(ctx context.Context, inputs []lim.UpdateInput, update lim.MultiUpdateFunc) error {
+	keys := make([]string, len(inputs))
+	shardIdx := make([]uint32, len(inputs))
+	for i, input := range inputs {
+		keys[i] = s.memoryKey(input.Key, input.Algorithm)
+		shardIdx[i] = s.shardIndex(keys[i])
+	}
+
+	// шарды блокируются по возрастанию номера, чтобы обновления не ждали друг друга вечно
+	locked := slices.Compact(slices.Sorted(slices.Values(shardIdx)))
+	for _, idx := range locked {
+		s.shards[idx].mu.Lock()
+	}
+	defer func() {
+		for _, idx := range locked {
+			s.shards[idx].mu.Unlock()
+		}
+	}()
+
+	now := time.Now()
+
+	states := make([]*lim.State, len(keys))
+	for i, key := range keys {
+		e, ok := s.shards[shardIdx[i]].get(key, now)
+		if !ok {
+			continue
+		}
+		st, err := inputs[i].Unmarsh.Unmarshal(e.data)
+		if err != nil {
+			return err
+		}
+		states[i] = st
+	}
+
+	newStates, err := update(states)
+	if err != nil || newStates == nil {
+		return err
+	}
+
+	var expireAt time.Time
+	if s.keyTTL > 0 {
+		expireAt = now.Add(s.keyTTL)
+	}
+	for i, st := range newStates {
+		if st == nil {
+			continue
+		}
+		data, err := st.Params.Marshal()
+		if err != nil {
+			return err
+		}
+		s.shards[shardIdx[i]].set(keys[i], data, expireAt)
+	}
+	return nil
+}
+
+func (s *memoryStorage) Get(ctx context.Context, input lim.UpdateInput) (*lim.State, error) {
+	key := s.memoryKey(input.Key, input.Algorithm)
+	sh := s.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := sh.get(key, time.Now())
+	if !ok {
+		return nil, nil
+	}
+	return input.Unmarsh.Unmarshal(e.data)
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, input lim.UpdateInput) error {
+	s.delete(s.memoryKey(input.Key, input.Algorithm))
+	return nil
+}
+
+func (s *memoryStorage) delete(key string) {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if el, ok := sh.entries[key]; ok {
+		sh.remove(el)
+	}
+}
+
+func (s *memoryStorage) Keys(ctx context.Context, algorithm, prefix string, limit int) ([]string, error) {
+	prefix, suffix := "state:"+prefix, ":"+algorithm
+	now := time.Now()
+
+	var keys []string
+	for _, sh := range s.shards {
+		if len(keys) == limit {
+			break
+		}
+		sh.mu.Lock()
+		for key, el := range sh.entries {
+			if len(keys) == limit {
+				break
+			}
+			if el.Value.(*memoryEntry).expired(now) ||
+				!strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) {
+				continue
+			}
+			keys = append(keys, strings.TrimSuffix(strings.TrimPrefix(key, "state:"), suffix))
+		}
+		sh.mu.Unlock()
+	}
+	return keys, nil
+}
+
+func (s *memoryStorage) Close() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+func (s *memoryStorage) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			for _, sh := range s.shards {
+				sh.removeExpired(now)
+			}
+		}
+	}
+}
+
+func (s *memoryStorage) shard(key string) *memoryShard {
+	return s.shards[s.shardIndex(key)]
+}
+
+func (s *memoryStorage) shardIndex(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % memoryShardsNum
+}
+
+func (s *memoryStorage) memoryKey(key, algorithm string) string {
+	return fmt.Sprintf("state:%s:%s", key, algorithm)
+}
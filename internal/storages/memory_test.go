@@ -0,0 +1,155 @@
+package storages
+
+import (
+	"context"
+	"fmt"
+	"gateway/internal/algorithm/fixedwindow"
+	"gateway/internal/clock"
+	"testing"
+	"time"
+)
+
+func (s *memoryStorage) len() int {
+	n := 0
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		n += sh.lru.Len()
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+func (s *memoryStorage) used() int64 {
+	var used int64
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		used += sh.used
+		sh.mu.Unlock()
+	}
+	return used
+}
+
+func TestMemoryStorageTTL(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	ctx := context.Background()
+
+	t.Run("expired key starts over", func(t *testing.T) {
+		stor := NewMemoryStorage(ttl, 0, 0)
+		lim := newFixedWindowLimiter(stor, fixedwindow.NewFixedWindow(1, time.Hour, clk))
+
+		if d, _ := lim.Allow(ctx, "client"); !d.Allowed {
+			t.Fatal("first request denied")
+		}
+		if d, _ := lim.Allow(ctx, "client"); d.Allowed {
+			t.Fatal("second request allowed before ttl")
+		}
+		time.Sleep(ttl)
+		if d, _ := lim.Allow(ctx, "client"); !d.Allowed {
+			t.Fatal("request denied after ttl")
+		}
+	})
+
+	t.Run("cleanup removes expired keys", func(t *testing.T) {
+		stor := NewMemoryStorage(ttl, 0, 10*time.Millisecond)
+		t.Cleanup(stor.Close)
+		lim := newFixedWindowLimiter(stor, fixedwindow.NewFixedWindow(1, time.Hour, clk))
+
+		for i := range 10 {
+			if _, err := lim.Allow(ctx, fmt.Sprint("client", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := stor.len(); n != 10 {
+			t.Fatalf("keys = %d, want 10", n)
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for stor.len() > 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("keys after cleanup = %d, want 0", stor.len())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if used := stor.used(); used != 0 {
+			t.Errorf("used = %d, want 0", used)
+		}
+	})
+}
+
+func TestMemoryShardEvictsLeastRecentlyUsed(t *testing.T) {
+	sh := newMemoryShard(3 * int64(len("k0")+len("data")))
+	var never time.Time
+	now := time.Now()
+
+	sh.set("k0", []byte("data"), never)
+	sh.set("k1", []byte("data"), never)
+	sh.set("k2", []byte("data"), never)
+	sh.get("k0", now) // k1 теперь давно неиспользованный
+	sh.set("k3", []byte("data"), never)
+
+	for key, want := range map[string]bool{"k0": true, "k1": false, "k2": true, "k3": true} {
+		if _, ok := sh.get(key, now); ok != want {
+			t.Errorf("%s present = %v, want %v", key, ok, want)
+		}
+	}
+
+	// только что записанный ключ не вытесняется, даже если он больше лимита
+	sh.set("big", make([]byte, 100), never)
+	if _, ok := sh.get("big", now); !ok {
+		t.Error("just written key evicted")
+	}
+	if n := sh.lru.Len(); n != 1 {
+		t.Errorf("keys = %d, want 1", n)
+	}
+}
+
+func TestMemoryStorageMaxMemory(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		maxMemory int64
+		// ключей не больше, чем помещается во все шарды
+		maxKeys int
+	}{
+		// меньше числа шардов: у каждого шарда остается последний ключ
+		{name: "below shard count", maxMemory: 10, maxKeys: memoryShardsNum},
+		{name: "unlimited", maxMemory: 0, maxKeys: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stor := NewMemoryStorage(0, tt.maxMemory, 0)
+			lim := newFixedWindowLimiter(stor, fixedwindow.NewFixedWindow(1, time.Hour, clk))
+
+			for i := range 1000 {
+				if _, err := lim.Allow(ctx, fmt.Sprint("client", i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := stor.len(); n > tt.maxKeys || tt.maxMemory == 0 && n != tt.maxKeys {
+				t.Errorf("keys = %d, want at most %d", n, tt.maxKeys)
+			}
+		})
+	}
+
+	t.Run("total size", func(t *testing.T) {
+		const maxMemory = 4096
+		stor := NewMemoryStorage(0, maxMemory, 0)
+		lim := newFixedWindowLimiter(stor, fixedwindow.NewFixedWindow(1, time.Hour, clk))
+
+		for i := range 1000 {
+			if _, err := lim.Allow(ctx, fmt.Sprint("client", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if used := stor.used(); used > maxMemory {
+			t.Errorf("used = %d, want at most %d", used, maxMemory)
+		}
+		if n := stor.len(); n == 0 || n >= 1000 {
+			t.Errorf("keys = %d, want some keys evicted", n)
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package storages
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	lim "gateway/internal/limiter"
+	"slices"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Сколько последних значений ключей уровней помнит хранилище
+	valueCacheSize = 10_000
+	// Попыток записи уровней, если режим хранилища - lock
+	casRetries = 10
+)
+
+// Новые состояния записываются, только если ни один ключ не изменился
+// с тех пор, как по нему вычислялись состояния. Иначе скрипт ничего
+// не записывает и возвращает текущие значения ключей.
+// ARGV[1] - время жизни ключей в миллисекундах, дальше по три аргумента на ключ:
+// флаги (v/n - ожидается значение или его отсутствие, w/- - записывать ли ключ),
+// ожидаемое значение и новое значение
+var compareAndSetScript = redis.NewScript(`
+local ttl = tonumber(ARGV[1])
+local current = redis.call('MGET', unpack(KEYS))
+
+for i = 1, #KEYS do
+	local expected = false
+	if string.sub(ARGV[3 * i - 1], 1, 1) == 'v' then
+		expected = ARGV[3 * i]
+	end
+	if current[i] ~= expected then
+		table.insert(current, 1, 0)
+		return current
+	end
+end
+
+for i = 1, #KEYS do
+	if string.sub(ARGV[3 * i - 1], 2, 2) == 'w' then
+		if ttl > 0 then
+			redis.call('SET', KEYS[i], ARGV[3 * i + 1], 'PX', ttl)
+		else
+			redis.call('SET', KEYS[i], ARGV[3 * i + 1])
+		end
+	end
+end
+return {1}
+`)
+
+// Состояния вычисляются по последним известным значениям ключей и записываются
+// одним скриптом, который сверяет эти значения с текущими. Если значения
+// устарели, скрипт возвращает текущие, и обновление повторяется.
+// Обычно это одно обращение к Redis в любом режиме хранилища
+func (s *redisStorage) UpdateMulti(ctx context.Context, inputs []lim.UpdateInput, update lim.MultiUpdateFunc) error {
+	keys := make([]string, len(inputs))
+	for i, input := range inputs {
+		keys[i] = s.redisKey(input.Key, input.Algorithm)
+	}
+
+	if s.maxRetries == 0 {
+		// ключи блокируются в одном порядке, чтобы обновления не ждали друг друга вечно
+		locked := slices.Compact(slices.Sorted(slices.Values(keys)))
+		for _, key := range locked {
+			s.mu.Lock(key)
+		}
+		defer func() {
+			for _, key := range locked {
+				s.mu.Unlock(key)
+			}
+		}()
+	}
+
+	ttl := s.keyTTL.Milliseconds()
+	if s.keyTTL > 0 && ttl == 0 {
+		ttl = 1
+	}
+
+	values := s.values.get(keys)
+	for range max(s.maxRetries, casRetries) {
+		written, err := s.updateMulti(values, inputs, update)
+		if err != nil {
+			return err
+		}
+
+		args := make([]any, 1, 1+3*len(keys))
+		args[0] = ttl
+		for i := range keys {
+			expected, _ := values[i].(string)
+			flags := "n"
+			if values[i] != nil {
+				flags = "v"
+			}
+			if written[i] != nil {
+				flags += "w"
+			} else {
+				flags += "-"
+			}
+			args = append(args, flags, expected, written[i])
+		}
+
+		res, err := compareAndSetScript.Run(ctx, s.rdb, keys, args...).Slice()
+		if err != nil {
+			return err
+		}
+		if len(res) == 0 {
+			return fmt.Errorf("%w: unexpected compare-and-set result", lim.ErrInvalidState)
+		}
+		if ok, _ := res[0].(int64); ok == 1 {
+			for i := range values {
+				if written[i] != nil {
+					values[i] = string(written[i])
+				}
+			}
+			s.values.set(keys, values)
+			return nil
+		}
+		if len(res) != len(keys)+1 {
+			return fmt.Errorf("%w: unexpected compare-and-set result", lim.ErrInvalidState)
+		}
+		values = res[1:]
+		s.values.set(keys, values)
+	}
+	return fmt.Errorf("%w: keys %q", ErrTooManyRetries, keys)
+}
+
+// Новые значения ключей, nil - ключ не записывается
+func (s *redisStorage) updateMulti(
+	values []any, inputs []lim.UpdateInput, update lim.MultiUpdateFunc,
+) ([][]byte, error) {
+	states := make([]*lim.State, len(values))
+	for i, val := range values {
+		str, ok := val.(string)
+		if !ok {
+			continue
+		}
+		var err error
+		if states[i], err = inputs[i].Unmarsh.Unmarshal([]byte(str)); err != nil {
+			return nil, err
+		}
+	}
+
+	newStates, err := update(states)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([][]byte, len(values))
+	for i, st := range newStates {
+		if st == nil {
+			continue
+		}
+		if data[i], err = st.Params.Marshal(); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+type cachedValue struct {
+	key string
+	val any
+}
+
+// Значения ключей, прочитанные или записанные последними. Это только
+// подсказка: скрипт все равно сверяет их со значениями в Redis.
+// Хранит не больше size ключей, вытесняются давно использованные
+type valueCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newValueCache(size int) *valueCache {
+	return &valueCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Неизвестное значение - nil, как у отсутствующего ключа
+func (c *valueCache) get(keys []string) []any {
+	values := make([]any, len(keys))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			values[i] = el.Value.(*cachedValue).val
+			c.lru.MoveToFront(el)
+		}
+	}
+	return values
+}
+
+func (c *valueCache) set(keys []string, values []any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			el.Value.(*cachedValue).val = values[i]
+			c.lru.MoveToFront(el)
+			continue
+		}
+		c.entries[key] = c.lru.PushFront(&cachedValue{key: key, val: values[i]})
+		if c.lru.Len() > c.size {
+			back := c.lru.Back()
+			c.lru.Remove(back)
+			delete(c.entries, back.Value.(*cachedValue).key)
+		}
+	}
+}
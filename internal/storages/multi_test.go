@@ -0,0 +1,154 @@
+package storages
+
+import (
+	"context"
+	"gateway/internal/algorithm"
+	"gateway/internal/algorithm/fixedwindow"
+	"gateway/internal/clock"
+	"gateway/internal/limiter"
+	"gateway/server/interfaces"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func newTieredLimiter(stor limiter.Storage, clk *clock.Fake, limits ...int) interfaces.Limiter {
+	tiers := make([]limiter.Tier, len(limits))
+	for i, limit := range limits {
+		tiers[i] = limiter.Tier{
+			Name: string(rune('a' + i)),
+			Facade: limiter.NewFacade(
+				"fixed_window",
+				fixedwindow.NewFixedWindow(limit, time.Minute, clk),
+				algorithm.NewStateUnmarshaler[fixedwindow.Params](),
+			),
+		}
+	}
+	return limiter.NewTieredLimiter(tiers, stor)
+}
+
+// Много вызовов Allow многоуровневого лимитера на одно хранилище
+// или на несколько хранилищ с общим Redis
+func TestRedisStorageUpdateMultiConcurrentAllow(t *testing.T) {
+	const (
+		callers   = 16
+		perCaller = 25
+	)
+
+	tests := []struct {
+		name      string
+		opts      []RedisOption
+		instances int
+	}{
+		{name: "lock single instance", instances: 1},
+		{name: "optimistic", opts: []RedisOption{WithOptimisticLocking(1000)}, instances: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rdb := newTestRedis(t)
+			clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+
+			lims := make([]interfaces.Limiter, tt.instances)
+			for i := range lims {
+				lims[i] = newTieredLimiter(NewRedisStorage(rdb, 0, tt.opts...), clk, 150, 100)
+			}
+
+			var allowed atomic.Int32
+			var wg sync.WaitGroup
+			for i := range callers {
+				lim := lims[i%len(lims)]
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range perCaller {
+						d, err := lim.Allow(context.Background(), "client")
+						if err != nil {
+							t.Errorf("Allow() error = %v", err)
+							return
+						}
+						if d.Allowed {
+							allowed.Add(1)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := int(allowed.Load()); got != 100 {
+				t.Errorf("allowed = %d, want 100", got)
+			}
+		})
+	}
+}
+
+// Считает команды, которые клиент отправил в Redis
+type commandCounter struct {
+	n atomic.Int32
+}
+
+func (c *commandCounter) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (c *commandCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.n.Add(1)
+		return next(ctx, cmd)
+	}
+}
+
+func (c *commandCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		c.n.Add(1)
+		return next(ctx, cmds)
+	}
+}
+
+// Если известные значения уровней не устарели, обновление - одно обращение к Redis
+func TestRedisStorageUpdateMultiRoundTrips(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	counter := &commandCounter{}
+	rdb.AddHook(counter)
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	lim := newTieredLimiter(NewRedisStorage(rdb, time.Minute), clk, 10, 5)
+	other := newTieredLimiter(NewRedisStorage(rdb, time.Minute), clk, 10, 5)
+	ctx := context.Background()
+
+	allow := func(lim interfaces.Limiter) interfaces.Decision {
+		t.Helper()
+		d, err := lim.Allow(ctx, "client")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// скрипт загружается при первом вызове
+	allow(lim)
+	before := counter.n.Load()
+	allow(lim)
+	if got := counter.n.Load() - before; got != 1 {
+		t.Fatalf("commands = %d, want 1", got)
+	}
+
+	// другой экземпляр изменил уровни: скрипт возвращает текущие значения,
+	// и решение вычисляется по ним
+	allow(other)
+	allow(other)
+	before = counter.n.Load()
+	d := allow(lim)
+	if got := counter.n.Load() - before; got != 2 {
+		t.Fatalf("commands = %d, want 2", got)
+	}
+	if d.Remaining != 0 || d.Tier != "b" {
+		t.Fatalf("decision = %+v, want b tier with no remaining quota", d)
+	}
+	if d := allow(lim); d.Allowed {
+		t.Fatalf("decision = %+v, want denial", d)
+	}
+	if d := allow(other); d.Allowed {
+		t.Fatalf("decision = %+v, want denial", d)
+	}
+}
@@ -2,35 +2,68 @@ package storages
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	lim "gateway/internal/limiter"
 	"gateway/pkg/keymutex"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	// Сколько ключей Redis просматривает за один SCAN
+	scanCount = 1000
+	// Попыток транзакции для общих состояний, если режим хранилища - lock
+	sharedRetries = 10
+)
+
 type redisStorage struct {
 	rdb    *redis.Client
 	keyTTL time.Duration
 	mu     *keymutex.KeyMutex[string]
+	// последние известные значения ключей уровней
+	values *valueCache
+
+	// 0 - блокировка в пределах процесса,
+	// иначе WATCH/MULTI с указанным числом попыток
+	maxRetries int
 }
 
-func NewRedisStorage(rdb *redis.Client, keyTTL time.Duration) *redisStorage {
-	return &redisStorage{
+type RedisOption func(*redisStorage)
+
+// Состояние обновляется транзакцией WATCH/MULTI, при конфликте
+// с другим экземпляром шлюза обновление повторяется
+func WithOptimisticLocking(maxRetries int) RedisOption {
+	return func(s *redisStorage) {
+		s.maxRetries = maxRetries
+	}
+}
+
+func NewRedisStorage(rdb *redis.Client, keyTTL time.Duration, opts ...RedisOption) *redisStorage {
+	s := &redisStorage{
 		rdb:    rdb,
 		keyTTL: keyTTL,
 		mu:     keymutex.New[string](),
+		values: newValueCache(valueCacheSize),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 func (s *redisStorage) Update(ctx context.Context, input lim.UpdateInput, update lim.UpdateFunc) error {
 	key := s.redisKey(input.Key, input.Algorithm)
+	if s.maxRetries > 0 {
+		return s.updateOptimistic(ctx, key, input.Unmarsh, update, s.keyTTL, s.maxRetries)
+	}
 
 	s.mu.Lock(key)
 	defer s.mu.Unlock(key)
 
-	state, err := s.get(ctx, key, input.Unmarsh)
+	state, err := s.get(ctx, s.rdb, key, input.Unmarsh)
 	if err != nil {
 		return err
 	}
@@ -49,12 +82,119 @@ func (s *redisStorage) Update(ctx context.Context, input lim.UpdateInput, update
 	return err
 }
 
+func (s *redisStorage) updateOptimistic(
+	ctx context.Context, key string, unmarsh lim.Unmarshaler[lim.State], update lim.UpdateFunc,
+	ttl time.Duration, retries int,
+) error {
+	txf := func(tx *redis.Tx) error {
+		state, err := s.get(ctx, tx, key, unmarsh)
+		if err != nil {
+			return err
+		}
+
+		newState, err := update(state)
+		if err != nil {
+			return err
+		}
+
+		data, err := newState.Params.Marshal()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, data, ttl)
+			return nil
+		})
+		return err
+	}
+
+	for range retries {
+		err := s.rdb.Watch(ctx, txf, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: key %q", ErrTooManyRetries, key)
+}
+
+func (s *redisStorage) Get(ctx context.Context, input lim.UpdateInput) (*lim.State, error) {
+	key := s.redisKey(input.Key, input.Algorithm)
+
+	typ, err := s.rdb.Type(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if typ == "zset" {
+		return s.getLog(ctx, key, input.Unmarsh)
+	}
+	return s.get(ctx, s.rdb, key, input.Unmarsh)
+}
+
+func (s *redisStorage) GetShared(ctx context.Context, input lim.UpdateInput) (*lim.State, error) {
+	return s.get(ctx, s.rdb, s.redisKey(input.Key, input.Algorithm), input.Unmarsh)
+}
+
+// Всегда транзакция WATCH/MULTI: блокировка внутри процесса не защищает
+// общее состояние от записи другим экземпляром шлюза
+func (s *redisStorage) UpdateShared(
+	ctx context.Context, input lim.UpdateInput, ttl time.Duration, update lim.UpdateFunc,
+) error {
+	key := s.redisKey(input.Key, input.Algorithm)
+	return s.updateOptimistic(ctx, key, input.Unmarsh, update, ttl, max(s.maxRetries, sharedRetries))
+}
+
+func (s *redisStorage) Delete(ctx context.Context, input lim.UpdateInput) error {
+	return s.rdb.Del(ctx, s.redisKey(input.Key, input.Algorithm)).Err()
+}
+
+// Ключи перебираются SCAN, поэтому список может быть неполным,
+// если состояния создаются и удаляются во время перебора
+func (s *redisStorage) Keys(ctx context.Context, algorithm, prefix string, limit int) ([]string, error) {
+	pattern := "state:" + escapeGlob(prefix) + "*:" + escapeGlob(algorithm)
+
+	var (
+		keys   []string
+		cursor uint64
+	)
+	for {
+		batch, next, err := s.rdb.Scan(ctx, cursor, pattern, scanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range batch {
+			if len(keys) == limit {
+				return keys, nil
+			}
+			keys = append(keys, strings.TrimSuffix(strings.TrimPrefix(key, "state:"), ":"+algorithm))
+		}
+		if cursor = next; cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// Экранирование символов шаблона MATCH
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 func (s *redisStorage) redisKey(key, algorithm string) string {
 	return fmt.Sprintf("state:%s:%s", key, algorithm)
 }
 
-func (s *redisStorage) get(ctx context.Context, key string, unmarsh lim.Unmarshaler[lim.State]) (*lim.State, error) {
-	val, err := s.rdb.Get(ctx, key).Bytes()
+func (s *redisStorage) get(
+	ctx context.Context, rdb redis.Cmdable, key string, unmarsh lim.Unmarshaler[lim.State],
+) (*lim.State, error) {
+	val, err := rdb.Get(ctx, key).Bytes()
 	if err == redis.Nil {
 		return nil, nil
 	}
@@ -64,3 +204,8 @@ func (s *redisStorage) get(ctx context.Context, key string, unmarsh lim.Unmarsha
 
 	return unmarsh.Unmarshal(val)
 }
+
+// Ключ другого типа: состояние записано в другом формате, Redis при этом доступен
+func isWrongType(err error) bool {
+	return redis.HasErrorPrefix(err, "WRONGTYPE")
+}
@@ -0,0 +1,158 @@
+package storages
+
+import (
+	"context"
+	"gateway/internal/algorithm"
+	"gateway/internal/algorithm/fixedwindow"
+	"gateway/internal/clock"
+	"gateway/internal/limiter"
+	"gateway/server/interfaces"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
+func newFixedWindowLimiter(stor limiter.Storage, alg limiter.Algorithm) interfaces.Limiter {
+	return limiter.NewLimiter(
+		limiter.NewFacade("fixed_window", alg, algorithm.NewStateUnmarshaler[fixedwindow.Params]()),
+		stor,
+	)
+}
+
+// Первые parties вызовов Action ждут друг друга: все экземпляры прочитали
+// состояние до того, как кто-то из них его записал
+type barrierAlgorithm struct {
+	limiter.Algorithm
+	parties int32
+	calls   atomic.Int32
+	arrived sync.WaitGroup
+}
+
+func newBarrierAlgorithm(alg limiter.Algorithm, parties int) *barrierAlgorithm {
+	b := &barrierAlgorithm{Algorithm: alg, parties: int32(parties)}
+	b.arrived.Add(parties)
+	return b
+}
+
+func (b *barrierAlgorithm) Action(state *limiter.State, cost int) (interfaces.Decision, *limiter.State, error) {
+	if b.calls.Add(1) <= b.parties {
+		b.arrived.Done()
+		b.arrived.Wait()
+	}
+	return b.Algorithm.Action(state, cost)
+}
+
+// Экземпляры шлюза с общим Redis: у каждого свое хранилище и своя блокировка
+func TestRedisStorageConcurrentInstances(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []RedisOption
+		wantAllowed int
+	}{
+		// блокировка в пределах процесса не мешает другим экземплярам
+		// прочитать то же состояние, поэтому лимит превышается
+		{name: "lock", wantAllowed: 2},
+		// транзакция второго экземпляра отменяется и повторяется
+		// по уже записанному состоянию
+		{name: "optimistic", opts: []RedisOption{WithOptimisticLocking(10)}, wantAllowed: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rdb := newTestRedis(t)
+			clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+			alg := newBarrierAlgorithm(fixedwindow.NewFixedWindow(1, time.Minute, clk), 2)
+
+			var allowed atomic.Int32
+			var wg sync.WaitGroup
+			for range 2 {
+				lim := newFixedWindowLimiter(NewRedisStorage(rdb, 0, tt.opts...), alg)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					d, err := lim.Allow(context.Background(), "client")
+					if err != nil {
+						t.Errorf("Allow() error = %v", err)
+						return
+					}
+					if d.Allowed {
+						allowed.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := int(allowed.Load()); got != tt.wantAllowed {
+				t.Errorf("allowed = %d, want %d", got, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+// Много вызовов Allow на одно хранилище или на несколько хранилищ с общим Redis
+func TestRedisStorageConcurrentAllow(t *testing.T) {
+	const (
+		limit     = 100
+		callers   = 16
+		perCaller = 25
+	)
+
+	tests := []struct {
+		name      string
+		opts      []RedisOption
+		instances int
+	}{
+		{name: "lock single instance", instances: 1},
+		{name: "optimistic", opts: []RedisOption{WithOptimisticLocking(1000)}, instances: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rdb := newTestRedis(t)
+			clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+			alg := fixedwindow.NewFixedWindow(limit, time.Minute, clk)
+
+			lims := make([]interfaces.Limiter, tt.instances)
+			for i := range lims {
+				lims[i] = newFixedWindowLimiter(NewRedisStorage(rdb, 0, tt.opts...), alg)
+			}
+
+			var allowed atomic.Int32
+			var wg sync.WaitGroup
+			for i := range callers {
+				lim := lims[i%len(lims)]
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range perCaller {
+						d, err := lim.Allow(context.Background(), "client")
+						if err != nil {
+							t.Errorf("Allow() error = %v", err)
+							return
+						}
+						if d.Allowed {
+							allowed.Add(1)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := int(allowed.Load()); got != limit {
+				t.Errorf("allowed = %d, want %d", got, limit)
+			}
+		})
+	}
+}
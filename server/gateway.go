@@ -17,14 +17,31 @@ import (
 )
 
 type Gateway struct {
-	EdgeLimiter     *limiter.RateLimiter
-	InternalLimiter *limiter.RateLimiter // может быть nil
-	Router          *Router
-	Log             interfaces.Logger
+	EdgeLimiter *limiter.RateLimiter
+	// теневые лимитеры видят все запросы, в том числе отклоненные EdgeLimiter
+	EdgeShadowLimiters []*limiter.RateLimiter
+	Router             *Router
+	Log                interfaces.Logger
+	// лимитеры, доступные админскому API, по именам
+	Limiters map[string]interfaces.LimiterAdmin
+
+	closers []func()
 }
 
 func (g *Gateway) Handler() http.Handler {
-	return g.EdgeLimiter.Wrap(http.HandlerFunc(g.serve))
+	h := g.EdgeLimiter.Wrap(http.HandlerFunc(g.serve))
+	for _, shadow := range g.EdgeShadowLimiters {
+		h = shadow.Wrap(h)
+	}
+	return h
+}
+
+// Останавливает фоновые горутины лимитеров, вызывается после остановки сервера.
+// Лимитеры останавливаются в обратном порядке, раньше своих хранилищ
+func (g *Gateway) Close() {
+	for i := len(g.closers) - 1; i >= 0; i-- {
+		g.closers[i]()
+	}
 }
 
 func (g *Gateway) serve(w http.ResponseWriter, r *http.Request) {
@@ -54,28 +71,131 @@ func (g *Gateway) serve(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 
-	if g.InternalLimiter == nil {
-		proxyAdapter.ServeHTTP(w, r)
-		return
+	// внутренние лимитеры находятся в proxyAdapter
+	ctx := context.WithValue(r.Context(), limiter.LimiterContextKey, proxyAdapter.Upstream())
+	ctx = context.WithValue(ctx, limiter.RouteContextKey, host+proxyAdapter.Prefix())
+	r, reservations := limiter.WithReservations(r.WithContext(ctx))
+	sw := proxy.NewStatusWriter(w)
+	proxyAdapter.ServeHTTP(sw, r)
+
+	// квота внутренних лимитеров возвращается, если сервис недоступен или ответил ошибкой
+	err := reservations.Settle(context.WithoutCancel(r.Context()), sw.Status())
+	if err != nil {
+		g.Log.Warn(r.Context(), "cannot refund limiter quota", map[string]any{"error": err})
 	}
+}
 
-	r = r.WithContext(context.WithValue(r.Context(), limiter.LimiterContextKey, proxyAdapter.Upstream()))
-	h := g.InternalLimiter.Wrap(proxyAdapter)
-	h.ServeHTTP(w, r)
+// Обработчик ошибок сервиса: квота, зарезервированная для запроса, возвращается
+func upstreamError(log interfaces.Logger, upstream string) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		if rs := limiter.ReservationsFrom(r.Context()); rs != nil {
+			rs.Fail()
+		}
+		log.Error(r.Context(), "proxy request failed", map[string]any{"upstream": upstream, "error": err})
+		w.WriteHeader(http.StatusBadGateway)
+	}
 }
 
 type GatewayBuilder struct {
-	router          *Router
-	edgeLimiter     *limiter.RateLimiter
-	internalLimiter *limiter.RateLimiter
-	logger          interfaces.Logger
-	err             error
+	router      *Router
+	edgeLimiter *limiter.RateLimiter
+	edgeShadows []*limiter.RateLimiter
+	admins      map[string]interfaces.LimiterAdmin
+	closers     []func()
+	logger      interfaces.Logger
+	err         error
 }
 
 type LimiterOptions struct {
 	Metric  interfaces.LimiterMetric
 	Limiter interfaces.Limiter
 	Log     interfaces.Logger
+
+	// могут быть nil
+	Shaper      interfaces.Shaper
+	QueueMetric interfaces.QueueMetric
+	Concurrency interfaces.ConcurrencyLimiter
+	// только для внутренних лимитеров
+	Observer interfaces.ProxyObserver
+	// nil - ключ по keyType
+	Key limiter.KeyExtractor
+	// nil - каждый запрос стоит 1
+	Cost limiter.RequestCost
+	// "" - limiter.FailOpen
+	FailPolicy     limiter.FailPolicy
+	DegradedMetric interfaces.DegradedMetric
+	// nil - локальные часы
+	Clock interfaces.Clock
+
+	Shadow       bool
+	ShadowMetric interfaces.ShadowMetric
+
+	// nil - правил для клиентов нет
+	Clients *limiter.ClientTiers
+	// nil - квота подсети клиента не проверяется
+	Subnet     interfaces.Limiter
+	SubnetMask limiter.SubnetMask
+	// nil - квоту расходует каждый запрос
+	CountOnStatus limiter.StatusFilter
+	// nil - квота не возвращается. Только для внутренних лимитеров
+	RefundOnStatus limiter.StatusFilter
+	// nil - ключи не блокируются
+	Bans      interfaces.Banlist
+	BanMetric interfaces.BanMetric
+	// метрика кэша отказов лимитера, задается до его сборки
+	DenyCacheMetric interfaces.DenyCacheMetric
+
+	// nil - лимитер недоступен админскому API
+	Admin interfaces.LimiterAdmin
+	Name  string
+
+	// останавливают фоновые горутины лимитера, когда он больше не нужен
+	// или шлюз останавливается. Заполняются при сборке лимитера
+	Closers []func()
+}
+
+func (opts LimiterOptions) options(keyType limiter.KeyType) []limiter.Option {
+	options := []limiter.Option{
+		limiter.WithKeyType(keyType),
+		limiter.WithMetric(opts.Metric),
+	}
+	if opts.Key != nil {
+		options = append(options, limiter.WithKeyExtractor(opts.Key))
+	}
+	if opts.Cost != nil {
+		options = append(options, limiter.WithCost(opts.Cost))
+	}
+	if opts.Clock != nil {
+		options = append(options, limiter.WithClock(opts.Clock))
+	}
+	if opts.FailPolicy != "" {
+		options = append(options, limiter.WithFailPolicy(opts.FailPolicy, opts.DegradedMetric))
+	}
+	if opts.Shadow {
+		options = append(options, limiter.WithShadow(opts.ShadowMetric))
+	}
+	if opts.Shaper != nil {
+		options = append(options, limiter.WithShaper(opts.Shaper, opts.QueueMetric))
+	}
+	if opts.Concurrency != nil {
+		options = append(options, limiter.WithConcurrency(opts.Concurrency))
+	}
+	if opts.Clients != nil {
+		options = append(options, limiter.WithClientTiers(opts.Clients))
+	}
+	if opts.Subnet != nil {
+		options = append(options, limiter.WithSubnetLimiter(opts.Subnet, opts.SubnetMask))
+	}
+	if opts.CountOnStatus != nil {
+		options = append(options, limiter.WithCountOnStatus(opts.CountOnStatus))
+	}
+	if opts.RefundOnStatus != nil {
+		options = append(options, limiter.WithRefundOnStatus(opts.RefundOnStatus))
+	}
+	if opts.Bans != nil {
+		options = append(options, limiter.WithBans(opts.Bans, opts.BanMetric))
+	}
+	return options
 }
 
 type CacheOptions struct {
@@ -93,34 +213,53 @@ type RouterOptions struct {
 	Settings config.RouterSettings
 	Proxy    ProxyOptions
 	Cache    *CacheOptions
+	Limiter  *InternalLimiterOptions // может быть nil
 }
 
 func NewGatewayBuilder() *GatewayBuilder {
-	return &GatewayBuilder{}
+	return &GatewayBuilder{admins: make(map[string]interfaces.LimiterAdmin)}
 }
 
+// Logger задается до Router: он нужен обработчикам ошибок сервисов
 func (b *GatewayBuilder) Router(opts RouterOptions) *GatewayBuilder {
 	if b.err != nil {
 		return b
 	}
+	if b.logger == nil {
+		b.err = fmt.Errorf("logger must be configured before router")
+		return b
+	}
 
 	r := NewRouter()
+	policies := newLimiterPolicies(opts.Limiter, b.register)
 
-	makeAdapter := func(upstream, prefix string, cache *config.Caches) (*proxy.ReverseProxyAdapter, error) {
-		return b.createProxyAdapter(upstream, prefix, cache, opts.Proxy, opts.Cache)
+	makeAdapter := func(
+		upstream, prefix string, cache *config.Caches, levels ...policyLevel,
+	) (*proxy.ReverseProxyAdapter, error) {
+		policy, err := policies.resolve(levels...)
+		if err != nil {
+			return nil, err
+		}
+		return b.createProxyAdapter(upstream, prefix, cache, policy, opts.Proxy, opts.Cache)
 	}
 
 	settings := opts.Settings
 	for _, route := range settings.Routes {
 		host := route.Host
+		routeLevel := policyLevel{"route:" + host, route.Limiter}
 
 		if route.Default != nil {
-			up, err := resolveUpstream(route.Default.UpstreamAlias, settings.UpstreamsAliases)
+			up, upLimiter, err := resolveUpstream(route.Default.UpstreamAlias, settings.UpstreamsAliases)
 			if err != nil {
 				b.err = err
 				return b
 			}
-			adapter, err := makeAdapter(up, "", route.Default.Cache)
+			adapter, err := makeAdapter(
+				up, "", route.Default.Cache,
+				policyLevel{"default:" + host, route.Default.Limiter},
+				routeLevel,
+				policyLevel{"upstream:" + route.Default.UpstreamAlias, upLimiter},
+			)
 			if err != nil {
 				b.err = fmt.Errorf("cannot create default proxy for host %s: %w", host, err)
 				return b
@@ -129,13 +268,18 @@ func (b *GatewayBuilder) Router(opts RouterOptions) *GatewayBuilder {
 		}
 
 		for _, path := range route.Paths {
-			up, err := resolveUpstream(path.UpstreamAlias, settings.UpstreamsAliases)
+			up, upLimiter, err := resolveUpstream(path.UpstreamAlias, settings.UpstreamsAliases)
 			if err != nil {
 				b.err = err
 				return b
 			}
 
-			adapter, err := makeAdapter(up, path.Path, path.Cache)
+			adapter, err := makeAdapter(
+				up, path.Path, path.Cache,
+				policyLevel{"path:" + host + path.Path, path.Limiter},
+				routeLevel,
+				policyLevel{"upstream:" + path.UpstreamAlias, upLimiter},
+			)
 			if err != nil {
 				b.err = fmt.Errorf("cannot create proxy for route %s %s: %w", host, path.Path, err)
 				return b
@@ -146,13 +290,17 @@ func (b *GatewayBuilder) Router(opts RouterOptions) *GatewayBuilder {
 
 	if opts.Proxy.Default != nil {
 		def := opts.Proxy.Default
-		up, err := resolveUpstream(def.UpstreamAlias, settings.UpstreamsAliases)
+		up, upLimiter, err := resolveUpstream(def.UpstreamAlias, settings.UpstreamsAliases)
 		if err != nil {
 			b.err = err
 			return b
 		}
 
-		adapter, err := makeAdapter(up, "", def.Cache)
+		adapter, err := makeAdapter(
+			up, "", def.Cache,
+			policyLevel{"default", def.Limiter},
+			policyLevel{"upstream:" + def.UpstreamAlias, upLimiter},
+		)
 		if err != nil {
 			b.err = fmt.Errorf("cannot create global default proxy: %w", err)
 			return b
@@ -164,33 +312,49 @@ func (b *GatewayBuilder) Router(opts RouterOptions) *GatewayBuilder {
 	return b
 }
 
-func resolveUpstream(name string, upstreams map[string]string) (string, error) {
+// Адрес сервиса и настройки лимитера его псевдонима (могут быть nil)
+func resolveUpstream(name string, upstreams config.UpstreamsAliases) (string, *config.LimiterSettings, error) {
 	if name == "" {
-		return "", fmt.Errorf("empty upstream name")
+		return "", nil, fmt.Errorf("empty upstream name")
 	}
 	if upstreams == nil {
-		return name, nil
+		return name, nil, nil
 	}
-	if url, ok := upstreams[name]; ok {
-		return url, nil
+	if alias, ok := upstreams[name]; ok {
+		return alias.URL, alias.Limiter, nil
 	}
 
 	if strings.Contains(name, "://") {
-		return name, nil
+		return name, nil, nil
 	}
-	return "", fmt.Errorf("upstream alias %q not found", name)
+	return "", nil, fmt.Errorf("upstream alias %q not found", name)
 }
 
 func (b *GatewayBuilder) createProxyAdapter(
 	upstream string,
 	prefix string,
 	cacheMap *config.Caches,
+	policy *limiterPolicy,
 	proxyOpts ProxyOptions,
 	cacheOpts *CacheOptions,
 ) (*proxy.ReverseProxyAdapter, error) {
+	var options []proxy.Option
+	if policy != nil {
+		for _, shadow := range policy.shadows {
+			options = append(options, proxy.WithMiddlewares(shadow))
+		}
+		options = append(options, proxy.WithMiddlewares(policy.limiter))
+		if policy.observer != nil {
+			options = append(options, proxy.WithObserver(policy.observer))
+		}
+		if policy.refunds {
+			options = append(options, proxy.WithErrorHandler(upstreamError(b.logger, upstream)))
+		}
+	}
+
 	n := urlutils.NormalizePath(prefix)
 	if cacheMap == nil || len(*cacheMap) == 0 {
-		return proxy.NewReverseProxyAdapter(upstream, n, proxyOpts.Metric)
+		return proxy.NewReverseProxyAdapter(upstream, n, proxyOpts.Metric, options...)
 	}
 
 	if cacheOpts == nil {
@@ -217,7 +381,7 @@ func (b *GatewayBuilder) createProxyAdapter(
 		upstream,
 		n,
 		proxyOpts.Metric,
-		proxy.WithMiddlewares(mw),
+		append(options, proxy.WithMiddlewares(mw))...,
 	)
 }
 
@@ -234,26 +398,36 @@ func (b *GatewayBuilder) EdgeLimiter(opts LimiterOptions, global bool) *GatewayB
 	b.edgeLimiter = limiter.NewRateLimiter(
 		opts.Limiter,
 		opts.Log,
-		limiter.WithKeyType(keyType),
-		limiter.WithMetric(opts.Metric),
+		opts.options(keyType)...,
 	)
+	b.register(opts)
 	return b
 }
 
-func (b *GatewayBuilder) InternalLimiter(opts LimiterOptions) *GatewayBuilder {
+// Теневой лимитер рядом с edge лимитером, keyPrefix отделяет его состояние
+func (b *GatewayBuilder) EdgeShadowLimiter(opts LimiterOptions, global bool, keyPrefix string) *GatewayBuilder {
 	if b.err != nil {
 		return b
 	}
 
-	b.internalLimiter = limiter.NewRateLimiter(
-		opts.Limiter,
-		opts.Log,
-		limiter.WithKeyType(limiter.ContextValue),
-		limiter.WithMetric(opts.Metric),
-	)
+	keyType := limiter.IP
+	if global {
+		keyType = limiter.Global
+	}
+
+	options := append(opts.options(keyType), limiter.WithKeyPrefix(keyPrefix))
+	b.edgeShadows = append(b.edgeShadows, limiter.NewRateLimiter(opts.Limiter, opts.Log, options...))
+	b.register(opts)
 	return b
 }
 
+func (b *GatewayBuilder) register(opts LimiterOptions) {
+	if opts.Admin != nil {
+		b.admins[opts.Name] = opts.Admin
+	}
+	b.closers = append(b.closers, opts.Closers...)
+}
+
 func (b *GatewayBuilder) Logger(log interfaces.Logger) *GatewayBuilder {
 	b.logger = log
 	return b
@@ -274,9 +448,11 @@ func (b *GatewayBuilder) Build() (*Gateway, error) {
 	}
 
 	return &Gateway{
-		Router:          b.router,
-		EdgeLimiter:     b.edgeLimiter,
-		InternalLimiter: b.internalLimiter,
-		Log:             b.logger,
+		Router:             b.router,
+		EdgeLimiter:        b.edgeLimiter,
+		EdgeShadowLimiters: b.edgeShadows,
+		Log:                b.logger,
+		Limiters:           b.admins,
+		closers:            b.closers,
 	}, nil
 }
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gateway/server/interfaces"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultKeysLimit = 100
+	maxKeysLimit     = 10000
+)
+
+type adminHandler struct {
+	limiters map[string]interfaces.LimiterAdmin
+}
+
+// Админский API лимитеров. Лимитер выбирается параметром limiter, ключ - параметром key:
+// имена лимитеров и ключи содержат "/", поэтому в путь они не входят
+func Admin(prefix string, limiters map[string]interfaces.LimiterAdmin) http.Handler {
+	h := &adminHandler{limiters: limiters}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+prefix+"/limiters", h.list)
+	mux.HandleFunc("GET "+prefix+"/limiters/keys", h.keys)
+	mux.HandleFunc("GET "+prefix+"/limiters/state", h.state)
+	mux.HandleFunc("DELETE "+prefix+"/limiters/state", h.reset)
+	mux.HandleFunc("GET "+prefix+"/limiters/overrides", h.overrides)
+	mux.HandleFunc("PUT "+prefix+"/limiters/overrides", h.setOverride)
+	mux.HandleFunc("DELETE "+prefix+"/limiters/overrides", h.deleteOverride)
+	mux.HandleFunc("GET "+prefix+"/limiters/bans", h.bans)
+	mux.HandleFunc("DELETE "+prefix+"/limiters/bans", h.unban)
+	return mux
+}
+
+type overrideView struct {
+	Exempt  bool      `json:"exempt"`
+	Limit   int       `json:"limit,omitempty"`
+	Window  string    `json:"window,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+type overrideRequest struct {
+	Exempt bool   `json:"exempt"`
+	Limit  int    `json:"limit"`
+	Window string `json:"window"`
+	TTL    string `json:"ttl"`
+}
+
+func (h *adminHandler) list(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(h.limiters))
+	for name := range h.limiters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	writeJSON(w, http.StatusOK, map[string]any{"limiters": names})
+}
+
+func (h *adminHandler) keys(w http.ResponseWriter, r *http.Request) {
+	lim, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	limit := defaultKeysLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxKeysLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxKeysLimit))
+			return
+		}
+		limit = n
+	}
+
+	keys, err := lim.Keys(r.Context(), r.URL.Query().Get("prefix"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+func (h *adminHandler) state(w http.ResponseWriter, r *http.Request) {
+	lim, key, ok := h.limiterKey(w, r)
+	if !ok {
+		return
+	}
+
+	states, err := lim.State(r.Context(), key)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if states == nil {
+		writeError(w, http.StatusNotFound, "state not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"key": key, "state": states})
+}
+
+func (h *adminHandler) reset(w http.ResponseWriter, r *http.Request) {
+	lim, key, ok := h.limiterKey(w, r)
+	if !ok {
+		return
+	}
+
+	if err := lim.Reset(r.Context(), key); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *adminHandler) overrides(w http.ResponseWriter, r *http.Request) {
+	lim, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	overrides, err := lim.Overrides(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	views := make(map[string]overrideView, len(overrides))
+	for key, ov := range overrides {
+		views[key] = newOverrideView(ov)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"overrides": views})
+}
+
+func (h *adminHandler) setOverride(w http.ResponseWriter, r *http.Request) {
+	lim, key, ok := h.limiterKey(w, r)
+	if !ok {
+		return
+	}
+
+	var req overrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl <= 0 {
+		writeError(w, http.StatusBadRequest, "ttl must be a positive duration")
+		return
+	}
+	ov := interfaces.LimitOverride{
+		Exempt:  req.Exempt,
+		Limit:   req.Limit,
+		Expires: time.Now().Add(ttl),
+	}
+	if !req.Exempt {
+		if ov.Window, err = time.ParseDuration(req.Window); err != nil {
+			writeError(w, http.StatusBadRequest, "window must be a duration")
+			return
+		}
+	}
+
+	err = lim.SetOverride(r.Context(), key, ov)
+	switch {
+	case errors.Is(err, interfaces.ErrInvalidOverride), errors.Is(err, interfaces.ErrOverrideNotSupported):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"key": key, "override": newOverrideView(ov)})
+	}
+}
+
+func (h *adminHandler) deleteOverride(w http.ResponseWriter, r *http.Request) {
+	lim, key, ok := h.limiterKey(w, r)
+	if !ok {
+		return
+	}
+
+	if err := lim.DeleteOverride(r.Context(), key); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *adminHandler) bans(w http.ResponseWriter, r *http.Request) {
+	lim, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	bans, err := lim.Bans(r.Context())
+	switch {
+	case errors.Is(err, interfaces.ErrBansNotEnabled):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"bans": bans})
+	}
+}
+
+func (h *adminHandler) unban(w http.ResponseWriter, r *http.Request) {
+	lim, key, ok := h.limiterKey(w, r)
+	if !ok {
+		return
+	}
+
+	err := lim.Unban(r.Context(), key)
+	switch {
+	case errors.Is(err, interfaces.ErrBansNotEnabled):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *adminHandler) limiter(w http.ResponseWriter, r *http.Request) (interfaces.LimiterAdmin, bool) {
+	name := r.URL.Query().Get("limiter")
+	lim, ok := h.limiters[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "limiter not found: "+name)
+		return nil, false
+	}
+	return lim, true
+}
+
+func (h *adminHandler) limiterKey(w http.ResponseWriter, r *http.Request) (interfaces.LimiterAdmin, string, bool) {
+	lim, ok := h.limiter(w, r)
+	if !ok {
+		return nil, "", false
+	}
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "key is required")
+		return nil, "", false
+	}
+	return lim, key, true
+}
+
+func newOverrideView(ov interfaces.LimitOverride) overrideView {
+	v := overrideView{Exempt: ov.Exempt, Expires: ov.Expires}
+	if !ov.Exempt {
+		v.Limit, v.Window = ov.Limit, ov.Window.String()
+	}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -2,4 +2,11 @@ package interfaces
 
 import "errors"
 
-var ErrCacheNotFound = errors.New("cache not found")
+var (
+	ErrCacheNotFound = errors.New("cache not found")
+
+	ErrInvalidOverride      = errors.New("invalid override")
+	ErrOverrideNotSupported = errors.New("limiter does not support this override")
+
+	ErrBansNotEnabled = errors.New("bans are not enabled for limiter")
+)
@@ -8,19 +8,143 @@ import (
 )
 
 type LimiterMetric interface {
-	Inc(allowed bool, dest string)
+	// tier - уровень многоуровневой квоты, "" - лимитер с одной квотой
+	Inc(allowed bool, dest, tier string)
+}
+
+type QueueMetric interface {
+	Inc(dest string, cancelled bool)
+}
+
+// Решения лимитера в теневом режиме
+type ShadowMetric interface {
+	Inc(wouldReject bool, dest, tier string)
+}
+
+// Решения лимитера, принятые при недоступном хранилище
+type DegradedMetric interface {
+	Inc(dest, policy string)
+}
+
+type LimitMetric interface {
+	Set(dest string, limit float64)
 }
 
 type ProxyMetric interface {
 	Inc(dest string)
 }
 
+// Блокировки ключей, event - limiter.BanEvent или limiter.BannedEvent
+type BanMetric interface {
+	Inc(dest, event string)
+}
+
+// hit - запрос отклонен по локальному кэшу отказов
+type DenyCacheMetric interface {
+	Inc(hit bool)
+}
+
 type CacheMetric interface {
 	Inc(host, path, query string, hit bool)
 }
 
+// Часы, по которым алгоритмы лимитера считают время
+type Clock interface {
+	Now() time.Time
+}
+
+// Решение лимитера и состояние квоты ключа после него
+type Decision struct {
+	Allowed bool
+
+	// 0 - алгоритм не сообщает размер квоты
+	Limit     int64
+	Remaining int64
+	// время, когда квота полностью восстановится
+	Reset time.Time
+	// для отклоненного запроса - через сколько появится квота
+	RetryAfter time.Duration
+	// уровень многоуровневой квоты, по которому принято решение
+	Tier string
+}
+
+// Выданная квота: Commit оставляет ее израсходованной, Cancel возвращает.
+// Действует только первый вызов
+type Reservation interface {
+	Commit()
+	Cancel(ctx context.Context) error
+}
+
+// Лимитер, который может вернуть квоту запроса
+type ReservingLimiter interface {
+	Limiter
+	// для отклоненного запроса Reservation - nil
+	Reserve(ctx context.Context, key string, n int) (Decision, Reservation, error)
+}
+
 type Limiter interface {
-	Allow(ctx context.Context, key string) (bool, error)
+	Allow(ctx context.Context, key string) (Decision, error)
+	// запрос расходует n единиц квоты
+	AllowN(ctx context.Context, key string, n int) (Decision, error)
+}
+
+type Shaper interface {
+	Limiter
+	// Время, до которого нужно задержать запрос, если решение его допускает.
+	// Cancel у res освобождает место в очереди, если запрос не дождался.
+	// Для отклоненного запроса res - nil
+	Wait(ctx context.Context, key string, n int) (until time.Time, decision Decision, res Reservation, err error)
+}
+
+type ConcurrencyLimiter interface {
+	// release освобождает слот, его нужно вызвать по завершении запроса
+	Acquire(ctx context.Context, key string) (release func() error, ok bool, err error)
+}
+
+// Временное исключение для ключа, действует вместо настроенного лимита до Expires
+type LimitOverride struct {
+	// ключ не ограничивается
+	Exempt bool
+	// если не Exempt - не больше Limit единиц квоты за Window
+	Limit  int
+	Window time.Duration
+
+	Expires time.Time
+}
+
+// Просмотр и изменение состояния лимитера по ключам.
+// Ключи передаются так, как их видит лимитер, вместе с префиксом политики
+type LimiterAdmin interface {
+	// Ключи с префиксом prefix, для которых хранится состояние, не больше limit
+	Keys(ctx context.Context, prefix string, limit int) ([]string, error)
+	// Состояния алгоритмов ключа по их именам, nil - состояния нет
+	State(ctx context.Context, key string) (map[string]any, error)
+	Reset(ctx context.Context, key string) error
+
+	Overrides(ctx context.Context) (map[string]LimitOverride, error)
+	SetOverride(ctx context.Context, key string, override LimitOverride) error
+	DeleteOverride(ctx context.Context, key string) error
+
+	// Заблокированные ключи и время окончания блокировки
+	Bans(ctx context.Context) (map[string]time.Time, error)
+	Unban(ctx context.Context, key string) error
+}
+
+// Временные блокировки ключей, которые продолжают получать отказы
+type Banlist interface {
+	// проверка без обращения к хранилищу, retryAfter - сколько осталось до окончания блокировки
+	Banned(key string) (retryAfter time.Duration, ok bool)
+	// учитывает отказ без ожидания хранилища
+	Strike(key string)
+}
+
+type ProxyObserver interface {
+	Observe(upstream string, latency time.Duration, status int)
+}
+
+type AdaptiveLimiter interface {
+	ConcurrencyLimiter
+	ProxyObserver
 }
 
 type Middleware interface {
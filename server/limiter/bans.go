@@ -0,0 +1,48 @@
+package limiter
+
+import (
+	"gateway/server/interfaces"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// ключ заблокирован
+	BanEvent = "ban"
+	// отклонен запрос заблокированного ключа
+	BannedEvent = "banned"
+)
+
+// Ключ, который продолжает получать отказы, блокируется: его запросы
+// отклоняются до алгоритма и правил для клиентов. metric может быть nil
+func WithBans(bans interfaces.Banlist, metric interfaces.BanMetric) Option {
+	return func(rl *RateLimiter) {
+		rl.bans = bans
+		rl.banMetric = metric
+	}
+}
+
+// true - ключ заблокирован, запрос отклонен
+func (rl *RateLimiter) serveBanned(w http.ResponseWriter, r *http.Request, key string) bool {
+	retryAfter, ok := rl.bans.Banned(key)
+	if !ok {
+		return false
+	}
+	if rl.banMetric != nil {
+		rl.banMetric.Inc(key, BannedEvent)
+	}
+
+	w.Header().Set(retryAfterHeader, strconv.FormatInt(max(seconds(retryAfter), 1), 10))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	return true
+}
+
+// Учитывает отказ для блокировки. Отказ по правилу для клиента
+// учитывается для ключа лимитера, по которому проверяется блокировка.
+// Отказы копятся локально, поэтому отказ из кэша отказов тоже не обращается к хранилищу
+func (rl *RateLimiter) strike(r *http.Request) {
+	if rl.bans == nil || rl.shadow {
+		return
+	}
+	rl.bans.Strike(rl.key(r))
+}
@@ -0,0 +1,134 @@
+package limiter
+
+import (
+	"fmt"
+	"gateway/server/interfaces"
+	"gateway/server/urlutils"
+	"net/http"
+	"net/netip"
+	"path"
+	"sync/atomic"
+)
+
+type ClientAction string
+
+const (
+	// запросы клиента ограничивает Limiter правила
+	ClientLimit ClientAction = "limit"
+	ClientAllow ClientAction = "allow"
+	ClientDeny  ClientAction = "deny"
+)
+
+// Правило для группы клиентов. Клиент подходит, если его ключ подходит
+// под один из шаблонов Keys или его IP входит в одну из сетей CIDRs
+type ClientRule struct {
+	// отделяет состояние правила от состояния основного лимитера
+	Name string
+	// шаблоны path.Match, сравниваются с ключом без префикса политики
+	Keys   []string
+	CIDRs  []netip.Prefix
+	Action ClientAction
+	// только для ClientLimit
+	Limiter interfaces.Limiter
+}
+
+// Правила для клиентов, которые можно заменить без перезапуска.
+// Действует первое подходящее правило
+type ClientTiers struct {
+	rules atomic.Pointer[[]ClientRule]
+}
+
+func (rule ClientRule) Validate() error {
+	if len(rule.Keys) == 0 && len(rule.CIDRs) == 0 {
+		return fmt.Errorf("client rule %s: keys or cidrs are required", rule.Name)
+	}
+	for _, pattern := range rule.Keys {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("client rule %s: invalid key pattern %q: %w", rule.Name, pattern, err)
+		}
+	}
+
+	switch rule.Action {
+	case ClientAllow, ClientDeny:
+		if rule.Limiter != nil {
+			return fmt.Errorf("client rule %s: limiter is allowed only for %s action", rule.Name, ClientLimit)
+		}
+	case ClientLimit:
+		if rule.Limiter == nil {
+			return fmt.Errorf("client rule %s: limiter is required for %s action", rule.Name, ClientLimit)
+		}
+	default:
+		return fmt.Errorf("client rule %s: unknown action %s", rule.Name, rule.Action)
+	}
+	return nil
+}
+
+// Правила должны пройти ClientRule.Validate
+func NewClientTiers(rules []ClientRule) *ClientTiers {
+	t := &ClientTiers{}
+	t.Store(rules)
+	return t
+}
+
+func (t *ClientTiers) Store(rules []ClientRule) {
+	t.rules.Store(&rules)
+}
+
+func (t *ClientTiers) match(key, ip string) (ClientRule, bool) {
+	addr, addrErr := netip.ParseAddr(ip)
+	for _, rule := range *t.rules.Load() {
+		for _, pattern := range rule.Keys {
+			if ok, _ := path.Match(pattern, key); ok {
+				return rule, true
+			}
+		}
+		if addrErr != nil {
+			continue
+		}
+		for _, prefix := range rule.CIDRs {
+			if prefix.Contains(addr.Unmap()) {
+				return rule, true
+			}
+		}
+	}
+	return ClientRule{}, false
+}
+
+// Правила для клиентов проверяются до основного лимитера
+func WithClientTiers(tiers *ClientTiers) Option {
+	return func(rl *RateLimiter) {
+		rl.clients = tiers
+	}
+}
+
+// false - правило не подошло, запрос обрабатывает основной лимитер
+func (rl *RateLimiter) serveClient(w http.ResponseWriter, r *http.Request, next http.Handler) bool {
+	rule, ok := rl.clients.match(rl.baseKey(r), urlutils.GetIP(r))
+	if !ok {
+		return false
+	}
+	key := rl.key(r)
+
+	switch rule.Action {
+	case ClientAllow:
+		if rl.shadow {
+			rl.observeShadow(r, key, true, rule.Name)
+		} else {
+			rl.metric.Inc(true, key, rule.Name)
+		}
+		next.ServeHTTP(w, r)
+
+	case ClientDeny:
+		if rl.shadow {
+			rl.observeShadow(r, key, false, rule.Name)
+			next.ServeHTTP(w, r)
+			return true
+		}
+		rl.metric.Inc(false, key, rule.Name)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+
+	case ClientLimit:
+		rl.serveLimited(w, r, next, rule.Limiter, "client:"+rule.Name+":"+key, rule.Name)
+	}
+	return true
+}
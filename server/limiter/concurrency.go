@@ -0,0 +1,45 @@
+package limiter
+
+import (
+	"gateway/server/urlutils"
+	"net/http"
+)
+
+func (rl *RateLimiter) serveConcurrent(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	ip, key := urlutils.GetIP(r), rl.key(r)
+
+	release, allow, err := rl.concurrency.Acquire(r.Context(), key)
+	if err != nil {
+		if rl.fail(w, r, key, err) {
+			next.ServeHTTP(w, r)
+		}
+		return
+	}
+	rl.log.Debug(
+		r.Context(),
+		"handle request",
+		map[string]any{"from": ip, "to": urlutils.GetHost(r), "allowed": allow},
+	)
+
+	if rl.shadow {
+		rl.observeShadow(r, key, allow, "")
+	} else {
+		rl.metric.Inc(allow, key, "")
+	}
+	if !allow {
+		if rl.shadow {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		rl.strike(r)
+		return
+	}
+
+	defer func() {
+		if err := release(); err != nil {
+			rl.log.Warn(r.Context(), "concurrency slot release failed", map[string]any{"key": key, "error": err})
+		}
+	}()
+	next.ServeHTTP(w, r)
+}
@@ -0,0 +1,54 @@
+package limiter
+
+import (
+	"fmt"
+	"gateway/server/urlutils"
+	"net/http"
+	"path"
+	"strings"
+)
+
+type CostRule struct {
+	// "" - любой метод
+	Method string
+	// шаблон path.Match, "" - любой путь
+	Path string
+	Cost int
+	// если задан, за каждые BodyBytes тела запроса добавляется единица.
+	// Учитывается только Content-Length
+	BodyBytes int64
+}
+
+// Сколько единиц квоты расходует запрос
+type RequestCost func(r *http.Request) int
+
+// Стоимость по первому подходящему правилу, 1 - если ни одно не подошло.
+// Стоимость не бывает меньше 1
+func NewRequestCost(rules []CostRule) (RequestCost, error) {
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Path, "/"); err != nil {
+			return nil, fmt.Errorf("invalid cost path pattern %q: %w", rule.Path, err)
+		}
+	}
+
+	return func(r *http.Request) int {
+		p := urlutils.NormalizePath(r.URL.Path)
+		for _, rule := range rules {
+			if rule.Method != "" && !strings.EqualFold(rule.Method, r.Method) {
+				continue
+			}
+			if rule.Path != "" {
+				if ok, _ := path.Match(rule.Path, p); !ok {
+					continue
+				}
+			}
+
+			cost := int64(rule.Cost)
+			if rule.BodyBytes > 0 && r.ContentLength > 0 {
+				cost += (r.ContentLength + rule.BodyBytes - 1) / rule.BodyBytes
+			}
+			return int(max(cost, 1))
+		}
+		return 1
+	}, nil
+}
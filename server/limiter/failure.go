@@ -0,0 +1,58 @@
+package limiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"gateway/server/interfaces"
+	"gateway/server/urlutils"
+	"net/http"
+)
+
+// Что делать с запросом, если лимитер не смог принять решение
+type FailPolicy string
+
+const (
+	FailOpen   FailPolicy = "open"
+	FailClosed FailPolicy = "closed"
+	// решения принимает резервное хранилище в памяти, поэтому ошибка
+	// означает, что недоступно и оно, и запрос отклоняется
+	FailLocal FailPolicy = "local"
+)
+
+// Режим при ошибке хранилища, по умолчанию FailOpen.
+// metric может быть nil
+func WithFailPolicy(policy FailPolicy, metric interfaces.DegradedMetric) Option {
+	return func(rl *RateLimiter) {
+		rl.failPolicy = policy
+		rl.degradedMetric = metric
+	}
+}
+
+// true - запрос нужно пропустить дальше
+func (rl *RateLimiter) fail(w http.ResponseWriter, r *http.Request, key string, err error) bool {
+	rl.log.Error(
+		r.Context(),
+		fmt.Sprintf("rate limiter failed from %s to %s", urlutils.GetIP(r), r.URL.String()),
+		map[string]any{"error": err, "policy": rl.failPolicy},
+	)
+	if rl.shadow {
+		return true
+	}
+
+	policy := rl.failPolicy
+	if policy == FailLocal {
+		policy = FailClosed
+	}
+	if rl.degradedMetric != nil {
+		rl.degradedMetric.Inc(key, string(policy))
+	}
+
+	if policy == FailOpen {
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]string{"error": "rate limiter unavailable"})
+	return false
+}
@@ -0,0 +1,42 @@
+package limiter
+
+import (
+	"gateway/server/interfaces"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	limitHeader      = "RateLimit-Limit"
+	remainingHeader  = "RateLimit-Remaining"
+	resetHeader      = "RateLimit-Reset"
+	retryAfterHeader = "Retry-After"
+)
+
+// Заголовки квоты по draft-ietf-httpapi-ratelimit-headers.
+// Если запрос прошел несколько лимитеров, остаются заголовки самого строгого.
+// now - время по часам алгоритма, принявшего решение
+func setRateLimitHeaders(h http.Header, d interfaces.Decision, now time.Time) {
+	if d.Limit <= 0 {
+		return
+	}
+
+	if prev := h.Get(remainingHeader); prev != "" {
+		if remaining, err := strconv.ParseInt(prev, 10, 64); err == nil && remaining < d.Remaining {
+			return
+		}
+	}
+
+	h.Set(limitHeader, strconv.FormatInt(d.Limit, 10))
+	h.Set(remainingHeader, strconv.FormatInt(d.Remaining, 10))
+	h.Set(resetHeader, strconv.FormatInt(seconds(d.Reset.Sub(now)), 10))
+	if !d.Allowed {
+		h.Set(retryAfterHeader, strconv.FormatInt(max(seconds(d.RetryAfter), 1), 10))
+	}
+}
+
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(max(d, 0).Seconds()))
+}
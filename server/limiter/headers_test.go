@@ -0,0 +1,49 @@
+package limiter
+
+import (
+	"gateway/server/interfaces"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Reset считается от часов алгоритма, а не от локальных
+func TestSetRateLimitHeaders(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name       string
+		decision   interfaces.Decision
+		reset      string
+		retryAfter string
+	}{
+		{
+			name:     "allowed",
+			decision: interfaces.Decision{Allowed: true, Limit: 10, Remaining: 4, Reset: now.Add(1500 * time.Millisecond)},
+			reset:    "2",
+		},
+		{
+			name:       "denied",
+			decision:   interfaces.Decision{Limit: 10, Reset: now.Add(30 * time.Second), RetryAfter: 250 * time.Millisecond},
+			reset:      "30",
+			retryAfter: "1",
+		},
+		{
+			name:     "reset in the past",
+			decision: interfaces.Decision{Allowed: true, Limit: 10, Remaining: 10, Reset: now.Add(-time.Minute)},
+			reset:    "0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			setRateLimitHeaders(h, tt.decision, now)
+			if got := h.Get(resetHeader); got != tt.reset {
+				t.Errorf("%s = %q, want %q", resetHeader, got, tt.reset)
+			}
+			if got := h.Get(retryAfterHeader); got != tt.retryAfter {
+				t.Errorf("%s = %q, want %q", retryAfterHeader, got, tt.retryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package limiter
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gateway/server/urlutils"
+	"net/http"
+	"strings"
+)
+
+// Значения из заголовков, параметров, cookie и токена длиннее maxKeyValueLen
+// заменяются хешем: клиент не должен раздувать ключи хранилища и метки метрик
+const maxKeyValueLen = 64
+
+// Источник ключа лимитера. false - в запросе нет нужных данных
+type KeyExtractor interface {
+	Extract(r *http.Request) (string, bool)
+}
+
+type KeyExtractorFunc func(r *http.Request) (string, bool)
+
+func (f KeyExtractorFunc) Extract(r *http.Request) (string, bool) { return f(r) }
+
+func IPKey() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		return urlutils.GetIP(r), true
+	})
+}
+
+func GlobalKey() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		return globalKey, true
+	})
+}
+
+func HostKey() KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		return urlutils.GetHost(r), true
+	})
+}
+
+// Значение, которое шлюз кладет в контекст запроса (сервис или маршрут).
+// Для edge лимитера отсутствует - он работает до маршрутизации
+func ContextValueKey(key ContextKey) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		v, ok := r.Context().Value(key).(string)
+		return v, ok && v != ""
+	})
+}
+
+func HeaderKey(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		v := r.Header.Get(name)
+		return boundedKey(v), v != ""
+	})
+}
+
+func QueryKey(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		v := r.URL.Query().Get(name)
+		return boundedKey(v), v != ""
+	})
+}
+
+func CookieKey(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		c, err := r.Cookie(name)
+		if err != nil || c.Value == "" {
+			return "", false
+		}
+		return boundedKey(c.Value), true
+	})
+}
+
+// Claim из Bearer токена в Authorization. Подпись токена не проверяется,
+// поэтому ключ годится только для распределения квоты, а не для доступа
+func JWTClaimKey(claim string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return "", false
+		}
+
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return "", false
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return "", false
+		}
+
+		var claims map[string]any
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			return "", false
+		}
+		v, ok := claims[claim]
+		if !ok || v == nil {
+			return "", false
+		}
+		return boundedKey(fmt.Sprint(v)), true
+	})
+}
+
+func boundedKey(v string) string {
+	if len(v) <= maxKeyValueLen {
+		return v
+	}
+	sum := sha256.Sum256([]byte(v))
+	return "sha256:" + hex.EncodeToString(sum[:16])
+}
+
+type namedKey struct {
+	name string
+	KeyExtractor
+}
+
+// Первый найденный источник из списка, иначе IP клиента.
+// При нескольких источниках к значению добавляется имя источника,
+// чтобы значения разных источников не совпадали
+type fallbackKey []namedKey
+
+func (k fallbackKey) Extract(r *http.Request) (string, bool) {
+	for _, src := range k {
+		if v, ok := src.Extract(r); ok {
+			if len(k) == 1 {
+				return v, true
+			}
+			return src.name + "=" + v, true
+		}
+	}
+	if len(k) == 1 {
+		return urlutils.GetIP(r), true
+	}
+	return "ip=" + urlutils.GetIP(r), true
+}
+
+type compositeKey []KeyExtractor
+
+func (k compositeKey) Extract(r *http.Request) (string, bool) {
+	values := make([]string, len(k))
+	for i, part := range k {
+		v, ok := part.Extract(r)
+		if !ok {
+			return "", false
+		}
+		values[i] = v
+	}
+	return strings.Join(values, "&"), true
+}
+
+// Разбирает описание ключа: части через "+", у каждой части
+// запасные источники через "|", например "header:X-Api-Key|ip + route".
+// Источники: ip, subnet[:<ipv4 bits>/<ipv6 bits>], global, host, upstream, route,
+// header:<имя>, query:<имя>, cookie:<имя>, jwt:<claim>
+func ParseKeyExtractor(spec string) (KeyExtractor, error) {
+	var parts compositeKey
+	for part := range strings.SplitSeq(spec, "+") {
+		var alternatives fallbackKey
+		for src := range strings.SplitSeq(part, "|") {
+			src = strings.TrimSpace(src)
+			e, err := parseKeySource(src)
+			if err != nil {
+				return nil, err
+			}
+			alternatives = append(alternatives, namedKey{src, e})
+		}
+		parts = append(parts, alternatives)
+	}
+
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return parts, nil
+}
+
+func parseKeySource(src string) (KeyExtractor, error) {
+	name, arg, _ := strings.Cut(src, ":")
+	switch name {
+	case "ip":
+		return IPKey(), nil
+	case "global":
+		return GlobalKey(), nil
+	case "host":
+		return HostKey(), nil
+	case "upstream":
+		return ContextValueKey(LimiterContextKey), nil
+	case "route":
+		return ContextValueKey(RouteContextKey), nil
+	case "subnet":
+		if arg == "" {
+			return SubnetKey(DefaultSubnetMask), nil
+		}
+		mask, err := parseSubnetMask(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key source %q: %w", src, err)
+		}
+		return SubnetKey(mask), nil
+	}
+
+	if arg == "" {
+		return nil, fmt.Errorf("unknown key source: %q", src)
+	}
+	switch name {
+	case "header":
+		return HeaderKey(arg), nil
+	case "query":
+		return QueryKey(arg), nil
+	case "cookie":
+		return CookieKey(arg), nil
+	case "jwt":
+		return JWTClaimKey(arg), nil
+	}
+	return nil, fmt.Errorf("unknown key source: %q", src)
+}
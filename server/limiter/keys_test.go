@@ -0,0 +1,175 @@
+package limiter
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func jwtWithPayload(payload string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return "Bearer " + enc([]byte(`{"alg":"none"}`)) + "." + enc([]byte(payload)) + ".sig"
+}
+
+func TestParseKeyExtractor(t *testing.T) {
+	longValue := strings.Repeat("k", maxKeyValueLen+1)
+
+	tests := []struct {
+		name  string
+		spec  string
+		setup func(r *http.Request)
+		want  string
+	}{
+		{name: "ip", spec: "ip", want: "203.0.113.7"},
+		{name: "global", spec: "global", want: globalKey},
+		{name: "host", spec: "host", want: "example.com"},
+		{name: "subnet default", spec: "subnet", want: "203.0.113.0/24"},
+		{name: "subnet mask", spec: "subnet:16/48", want: "203.0.0.0/16"},
+		{
+			name: "header",
+			spec: "header:X-Api-Key",
+			setup: func(r *http.Request) {
+				r.Header.Set("X-Api-Key", "abc")
+			},
+			want: "abc",
+		},
+		{
+			name: "query",
+			spec: "query:key",
+			setup: func(r *http.Request) {
+				r.URL.RawQuery = "key=abc"
+			},
+			want: "abc",
+		},
+		{
+			name: "cookie",
+			spec: "cookie:session",
+			setup: func(r *http.Request) {
+				r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+			},
+			want: "abc",
+		},
+		{
+			name: "jwt claim",
+			spec: "jwt:sub",
+			setup: func(r *http.Request) {
+				r.Header.Set("Authorization", jwtWithPayload(`{"sub":"user-1","n":7}`))
+			},
+			want: "user-1",
+		},
+		{
+			name: "jwt numeric claim",
+			spec: "jwt:n",
+			setup: func(r *http.Request) {
+				r.Header.Set("Authorization", jwtWithPayload(`{"n":7}`))
+			},
+			want: "7",
+		},
+		{
+			name: "malformed jwt falls back to ip",
+			spec: "jwt:sub",
+			setup: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer not-a-token")
+			},
+			want: "203.0.113.7",
+		},
+		{
+			name: "missing single source falls back to ip",
+			spec: "header:X-Api-Key",
+			want: "203.0.113.7",
+		},
+		{
+			name: "fallback names sources",
+			spec: "header:X-Api-Key | cookie:session",
+			setup: func(r *http.Request) {
+				r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+			},
+			want: "cookie:session=abc",
+		},
+		{
+			name: "fallback to ip names ip",
+			spec: "header:X-Api-Key|cookie:session",
+			want: "ip=203.0.113.7",
+		},
+		{
+			name: "composite",
+			spec: "header:X-Api-Key + route",
+			setup: func(r *http.Request) {
+				r.Header.Set("X-Api-Key", "abc")
+				*r = *r.WithContext(context.WithValue(r.Context(), RouteContextKey, "example.com/api"))
+			},
+			want: "abc&example.com/api",
+		},
+		{
+			// upstream есть только у внутренних лимитеров
+			name: "composite without context value",
+			spec: "ip + upstream",
+			want: "203.0.113.7&203.0.113.7",
+		},
+		{
+			name: "long header is hashed",
+			spec: "header:X-Api-Key",
+			setup: func(r *http.Request) {
+				r.Header.Set("X-Api-Key", longValue)
+			},
+			want: boundedKey(longValue),
+		},
+		{
+			name: "long cookie is hashed",
+			spec: "cookie:session",
+			setup: func(r *http.Request) {
+				r.AddCookie(&http.Cookie{Name: "session", Value: longValue})
+			},
+			want: boundedKey(longValue),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ParseKeyExtractor(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseKeyExtractor(%q) error = %v", tt.spec, err)
+			}
+
+			r, err := http.NewRequest(http.MethodGet, "http://example.com/api", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = "203.0.113.7:1234"
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+
+			// источник без значения заменяется IP, поэтому ключ есть всегда
+			if got, ok := e.Extract(r); !ok || got != tt.want {
+				t.Errorf("Extract() = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeyExtractorErrors(t *testing.T) {
+	for _, spec := range []string{"", "unknown", "header", "header:", "cookie:", "subnet:24", "subnet:33/64", "ip|"} {
+		if _, err := ParseKeyExtractor(spec); err == nil {
+			t.Errorf("ParseKeyExtractor(%q) error = nil, want error", spec)
+		}
+	}
+}
+
+func TestBoundedKey(t *testing.T) {
+	short := strings.Repeat("a", maxKeyValueLen)
+	if got := boundedKey(short); got != short {
+		t.Errorf("boundedKey(short) = %q, want unchanged", got)
+	}
+
+	long := short + "b"
+	got := boundedKey(long)
+	if !strings.HasPrefix(got, "sha256:") || len(got) > maxKeyValueLen {
+		t.Errorf("boundedKey(long) = %q, want short sha256 hash", got)
+	}
+	if got == boundedKey(short+"c") {
+		t.Error("different long values have the same key")
+	}
+}
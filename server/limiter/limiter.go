@@ -1,10 +1,10 @@
 package limiter
 
 import (
-	"fmt"
 	"gateway/server/interfaces"
 	"gateway/server/urlutils"
 	"net/http"
+	"time"
 )
 
 type KeyType string
@@ -19,21 +19,72 @@ const (
 	globalKey = "global"
 
 	LimiterContextKey ContextKey = "limiter"
+	// маршрут, выбранный шлюзом: хост и префикс пути
+	RouteContextKey ContextKey = "route"
 )
 
 type RateLimiter struct {
 	lim interfaces.Limiter
 	log interfaces.Logger
+	// часы алгоритмов: Reset и время ожидания из решений отсчитываются от них
+	clock interfaces.Clock
 
 	// IP - по умолчанию
 	keyType KeyType
+	// если задан, используется вместо keyType
+	extractor KeyExtractor
+	// nil - каждый запрос стоит 1
+	cost RequestCost
+
+	// FailOpen - по умолчанию
+	failPolicy     FailPolicy
+	degradedMetric interfaces.DegradedMetric
+
+	shadow       bool
+	shadowMetric interfaces.ShadowMetric
+	// отделяет ключи лимитера от ключей других лимитеров в том же хранилище
+	keyPrefix string
 
 	// nil - по умолчанию
 	metric interfaces.LimiterMetric
+
+	// если задан, запросы сверх лимита задерживаются, а не отклоняются
+	shaper      interfaces.Shaper
+	queueMetric interfaces.QueueMetric
+
+	// если задан, ограничивается число одновременных запросов
+	concurrency interfaces.ConcurrencyLimiter
+
+	// nil - правил для клиентов нет
+	clients *ClientTiers
+
+	// nil - квота подсети клиента не проверяется
+	subnet     interfaces.Limiter
+	subnetMask SubnetMask
+
+	// если задан, квоту расходуют только ответы с подходящим статусом
+	countOnStatus StatusFilter
+	// если задан, квота возвращается при ответах с подходящим статусом
+	refundOnStatus StatusFilter
+
+	// nil - ключи не блокируются
+	bans      interfaces.Banlist
+	banMetric interfaces.BanMetric
 }
 
 type Option func(*RateLimiter)
 
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// По умолчанию - локальные часы
+func WithClock(clock interfaces.Clock) Option {
+	return func(rl *RateLimiter) {
+		rl.clock = clock
+	}
+}
+
 func WithMetric(metric interfaces.LimiterMetric) Option {
 	return func(rl *RateLimiter) {
 		rl.metric = metric
@@ -46,9 +97,44 @@ func WithKeyType(keyType KeyType) Option {
 	}
 }
 
-// По умолчанию: keyType = IP, metric - nil
+func WithKeyExtractor(extractor KeyExtractor) Option {
+	return func(rl *RateLimiter) {
+		rl.extractor = extractor
+	}
+}
+
+func WithCost(cost RequestCost) Option {
+	return func(rl *RateLimiter) {
+		rl.cost = cost
+	}
+}
+
+func WithKeyPrefix(prefix string) Option {
+	return func(rl *RateLimiter) {
+		rl.keyPrefix = prefix
+	}
+}
+
+// Режим сглаживания: запрос ждет назначенного shaper времени
+// и отклоняется, только если ждать пришлось бы слишком долго
+func WithShaper(shaper interfaces.Shaper, metric interfaces.QueueMetric) Option {
+	return func(rl *RateLimiter) {
+		rl.shaper = shaper
+		rl.queueMetric = metric
+	}
+}
+
+// Режим ограничения числа одновременных запросов:
+// слот занимается до вызова next и освобождается после ответа
+func WithConcurrency(lim interfaces.ConcurrencyLimiter) Option {
+	return func(rl *RateLimiter) {
+		rl.concurrency = lim
+	}
+}
+
+// По умолчанию: keyType = IP, metric - nil, failPolicy = FailOpen
 func NewRateLimiter(lim interfaces.Limiter, log interfaces.Logger, options ...Option) *RateLimiter {
-	rl := &RateLimiter{metric: nil, lim: lim, keyType: IP, log: log}
+	rl := &RateLimiter{metric: nil, lim: lim, keyType: IP, log: log, failPolicy: FailOpen, clock: systemClock{}}
 	for _, opt := range options {
 		opt(rl)
 	}
@@ -58,39 +144,93 @@ func NewRateLimiter(lim interfaces.Limiter, log interfaces.Logger, options ...Op
 func (rl *RateLimiter) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			var key string
-
-			ip := urlutils.GetIP(r)
-			switch rl.keyType {
-			case Global:
-				key = globalKey
-			case IP:
-				key = ip
-			case ContextValue:
-				key = r.Context().Value(LimiterContextKey).(string)
-			}
-
-			allow, err := rl.lim.Allow(r.Context(), key)
-			if err != nil {
-				rl.log.Error(
-					r.Context(),
-					fmt.Sprintf("rate limiter failed from %s to %s", ip, r.URL.String()),
-					map[string]any{"error": err},
-				)
+			// в теневом режиме блокировок нет
+			if rl.bans != nil && !rl.shadow && rl.serveBanned(w, r, rl.key(r)) {
 				return
 			}
-			rl.log.Debug(
-				r.Context(),
-				"handle request",
-				map[string]any{"from": ip, "to": urlutils.GetHost(r), "allowed": allow},
-			)
-
-			rl.metric.Inc(allow, key)
-			if !allow {
-				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			if rl.clients != nil && rl.serveClient(w, r, next) {
 				return
 			}
-			next.ServeHTTP(w, r)
+
+			switch {
+			case rl.concurrency != nil:
+				rl.serveConcurrent(w, r, next)
+			case rl.shaper != nil:
+				rl.serveShaped(w, r, next)
+			case rl.countOnStatus != nil:
+				rl.serveCounted(w, r, next)
+			default:
+				rl.serveLimited(w, r, next, rl.limiter(r), rl.key(r), "")
+			}
 		},
 	)
 }
+
+// tier - уровень для метрик, если лимитер его не сообщает
+func (rl *RateLimiter) serveLimited(
+	w http.ResponseWriter, r *http.Request, next http.Handler,
+	lim interfaces.Limiter, key, tier string,
+) {
+	decision, err := rl.allowN(r, lim, key)
+	if err != nil {
+		if rl.fail(w, r, key, err) {
+			next.ServeHTTP(w, r)
+		}
+		return
+	}
+	rl.log.Debug(
+		r.Context(),
+		"handle request",
+		map[string]any{"from": urlutils.GetIP(r), "to": urlutils.GetHost(r), "allowed": decision.Allowed},
+	)
+	if decision.Tier == "" {
+		decision.Tier = tier
+	}
+
+	if rl.shadow {
+		rl.observeShadow(r, key, decision.Allowed, decision.Tier)
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	rl.metric.Inc(decision.Allowed, key, decision.Tier)
+	setRateLimitHeaders(w.Header(), decision, rl.clock.Now())
+	if !decision.Allowed {
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		rl.strike(r)
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+func (rl *RateLimiter) requestCost(r *http.Request) int {
+	if rl.cost == nil {
+		return 1
+	}
+	return rl.cost(r)
+}
+
+func (rl *RateLimiter) key(r *http.Request) string {
+	key := rl.baseKey(r)
+	if rl.keyPrefix == "" {
+		return key
+	}
+	return rl.keyPrefix + ":" + key
+}
+
+// Ключ без префикса политики
+func (rl *RateLimiter) baseKey(r *http.Request) string {
+	var key string
+	switch {
+	case rl.extractor != nil:
+		// источник без значения заменяется IP, поэтому ключ есть всегда
+		key, _ = rl.extractor.Extract(r)
+	case rl.keyType == Global:
+		key = globalKey
+	case rl.keyType == ContextValue:
+		key = r.Context().Value(LimiterContextKey).(string)
+	default:
+		key = urlutils.GetIP(r)
+	}
+	return key
+}
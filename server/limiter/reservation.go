@@ -0,0 +1,92 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"gateway/server/interfaces"
+	"net/http"
+	"sync"
+)
+
+type reservationsKey struct{}
+
+type reservedQuota struct {
+	res    interfaces.Reservation
+	refund StatusFilter
+}
+
+// Квота, зарезервированная внутренними лимитерами за время одного запроса.
+// После ответа сервиса ее возвращают или оставляют израсходованной
+type Reservations struct {
+	mu     sync.Mutex
+	quotas []reservedQuota
+	failed bool
+}
+
+func WithReservations(r *http.Request) (*http.Request, *Reservations) {
+	rs := &Reservations{}
+	return r.WithContext(context.WithValue(r.Context(), reservationsKey{}, rs)), rs
+}
+
+// nil - запрос обрабатывается без резервирования
+func ReservationsFrom(ctx context.Context) *Reservations {
+	rs, _ := ctx.Value(reservationsKey{}).(*Reservations)
+	return rs
+}
+
+// Сервис недоступен: квота возвращается при любом статусе ответа
+func (rs *Reservations) Fail() {
+	rs.mu.Lock()
+	rs.failed = true
+	rs.mu.Unlock()
+}
+
+// Возвращает квоту лимитеров, для которых status подходит под refund_on_status
+func (rs *Reservations) Settle(ctx context.Context, status int) error {
+	rs.mu.Lock()
+	quotas, failed := rs.quotas, rs.failed
+	rs.quotas = nil
+	rs.mu.Unlock()
+
+	var errs []error
+	for _, q := range quotas {
+		if !failed && !q.refund(status) {
+			q.res.Commit()
+			continue
+		}
+		if err := q.res.Cancel(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (rs *Reservations) add(res interfaces.Reservation, refund StatusFilter) {
+	rs.mu.Lock()
+	rs.quotas = append(rs.quotas, reservedQuota{res, refund})
+	rs.mu.Unlock()
+}
+
+// Квота возвращается, если сервис недоступен или ответил статусом из filter.
+// Работает для внутренних лимитеров с interfaces.ReservingLimiter
+func WithRefundOnStatus(filter StatusFilter) Option {
+	return func(rl *RateLimiter) {
+		rl.refundOnStatus = filter
+	}
+}
+
+// Резервирует квоту, если ее можно будет вернуть
+func (rl *RateLimiter) allowN(r *http.Request, lim interfaces.Limiter, key string) (interfaces.Decision, error) {
+	n := rl.requestCost(r)
+	reserving, ok := lim.(interfaces.ReservingLimiter)
+	rs := ReservationsFrom(r.Context())
+	if rl.refundOnStatus == nil || rl.shadow || !ok || rs == nil {
+		return lim.AllowN(r.Context(), key, n)
+	}
+
+	d, res, err := reserving.Reserve(r.Context(), key, n)
+	if res != nil {
+		rs.add(res, rl.refundOnStatus)
+	}
+	return d, err
+}
@@ -0,0 +1,29 @@
+package limiter
+
+import (
+	"gateway/server/interfaces"
+	"gateway/server/urlutils"
+	"net/http"
+)
+
+// Теневой режим: решение лимитера только записывается в метрики и лог,
+// запрос пропускается всегда. metric может быть nil
+func WithShadow(metric interfaces.ShadowMetric) Option {
+	return func(rl *RateLimiter) {
+		rl.shadow = true
+		rl.shadowMetric = metric
+	}
+}
+
+func (rl *RateLimiter) observeShadow(r *http.Request, key string, allowed bool, tier string) {
+	if !allowed {
+		rl.log.Info(
+			r.Context(),
+			"limiter would reject request",
+			map[string]any{"from": urlutils.GetIP(r), "to": urlutils.GetHost(r), "key": key, "tier": tier},
+		)
+	}
+	if rl.shadowMetric != nil {
+		rl.shadowMetric.Inc(!allowed, key, tier)
+	}
+}
@@ -0,0 +1,76 @@
+package limiter
+
+import (
+	"context"
+	"gateway/server/urlutils"
+	"net/http"
+	"time"
+)
+
+func (rl *RateLimiter) serveShaped(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	ip, key := urlutils.GetIP(r), rl.key(r)
+
+	until, decision, res, err := rl.shaper.Wait(r.Context(), key, rl.requestCost(r))
+	if err != nil {
+		if rl.fail(w, r, key, err) {
+			next.ServeHTTP(w, r)
+		}
+		return
+	}
+
+	delay := until.Sub(rl.clock.Now())
+	rl.log.Debug(
+		r.Context(),
+		"handle request",
+		map[string]any{"from": ip, "to": urlutils.GetHost(r), "allowed": decision.Allowed, "delay": delay},
+	)
+
+	// в теневом режиме запрос не задерживается
+	if rl.shadow {
+		rl.observeShadow(r, key, decision.Allowed, decision.Tier)
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	rl.metric.Inc(decision.Allowed, key, decision.Tier)
+	setRateLimitHeaders(w.Header(), decision, rl.clock.Now())
+	if !decision.Allowed {
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		rl.strike(r)
+		return
+	}
+
+	if delay > 0 {
+		err = wait(r.Context(), delay)
+		if rl.queueMetric != nil {
+			rl.queueMetric.Inc(key, err != nil)
+		}
+		if err != nil {
+			rl.log.Debug(
+				r.Context(),
+				"request cancelled while queued",
+				map[string]any{"from": ip, "to": urlutils.GetHost(r), "error": err},
+			)
+			// запрос не дошел до сервиса, его место в очереди достается следующим
+			ctx := context.WithoutCancel(r.Context())
+			if err = res.Cancel(ctx); err != nil {
+				rl.log.Warn(ctx, "cannot release queue slot", map[string]any{"key": key, "error": err})
+			}
+			return
+		}
+	}
+	res.Commit()
+	next.ServeHTTP(w, r)
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,63 @@
+package limiter
+
+import (
+	"context"
+	"gateway/internal/algorithm"
+	"gateway/internal/algorithm/leakybucket"
+	"gateway/internal/clock"
+	lim "gateway/internal/limiter"
+	"gateway/internal/logging"
+	"gateway/internal/storages"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type nopMetric struct{}
+
+func (nopMetric) Inc(bool, string, string) {}
+
+// Клиент, отменивший запрос в очереди, не занимает место следующих
+func TestShapedCancelReleasesSlot(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	shaper, err := lim.NewShaper(
+		lim.NewFacade(
+			"leaky_bucket",
+			leakybucket.NewLeakyBucket(1, time.Minute, clk),
+			algorithm.NewStateUnmarshaler[leakybucket.Params](),
+		),
+		storages.NewMemoryStorage(0, 0, 0),
+	)
+	if err != nil {
+		t.Fatalf("NewShaper() error = %v", err)
+	}
+	rl := NewRateLimiter(
+		shaper,
+		logging.NewSlogAdapter(slog.New(slog.DiscardHandler)),
+		WithMetric(nopMetric{}),
+		WithClock(clk),
+		WithShaper(shaper, nil),
+	)
+
+	calls := 0
+	h := rl.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls++ }))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil).WithContext(ctx)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if calls != 1 {
+		t.Fatalf("upstream calls = %d, want 1", calls)
+	}
+
+	until, _, _, err := shaper.Wait(context.Background(), "192.0.2.1", 1)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if want := clk.Now().Add(time.Second); !until.Equal(want) {
+		t.Errorf("until = %v, want %v", until, want)
+	}
+}
@@ -0,0 +1,106 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"gateway/server/proxy"
+	"gateway/server/urlutils"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Ответы, которые расходуют квоту
+type StatusFilter func(status int) bool
+
+// Коды ответа ("401") и классы ("5xx")
+func ParseStatusFilter(specs []string) (StatusFilter, error) {
+	codes := make(map[int]struct{})
+	var classes []int
+	for _, spec := range specs {
+		spec = strings.ToLower(strings.TrimSpace(spec))
+		if class, ok := strings.CutSuffix(spec, "xx"); ok {
+			n, err := strconv.Atoi(class)
+			if err != nil || n < 1 || n > 5 {
+				return nil, fmt.Errorf("invalid status class %q", spec)
+			}
+			classes = append(classes, n)
+			continue
+		}
+		code, err := strconv.Atoi(spec)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status code %q", spec)
+		}
+		codes[code] = struct{}{}
+	}
+
+	return func(status int) bool {
+		if _, ok := codes[status]; ok {
+			return true
+		}
+		for _, class := range classes {
+			if status/100 == class {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// Режим учета по ответу: квота расходуется после ответа и только если
+// его статус подходит под filter. До проксирования квота проверяется
+// без списания, и запрос отклоняется, когда ее не хватает на стоимость запроса
+func WithCountOnStatus(filter StatusFilter) Option {
+	return func(rl *RateLimiter) {
+		rl.countOnStatus = filter
+	}
+}
+
+func (rl *RateLimiter) serveCounted(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	ip, key, lim := urlutils.GetIP(r), rl.key(r), rl.limiter(r)
+
+	// n = 0 - проверка без списания
+	cost := rl.requestCost(r)
+	decision, err := lim.AllowN(r.Context(), key, 0)
+	if err != nil {
+		if rl.fail(w, r, key, err) {
+			next.ServeHTTP(w, r)
+		}
+		return
+	}
+	if decision.Limit != 0 && decision.Remaining < int64(cost) {
+		decision.Allowed = false
+		if decision.RetryAfter <= 0 {
+			decision.RetryAfter = decision.Reset.Sub(rl.clock.Now())
+		}
+	}
+	rl.log.Debug(
+		r.Context(),
+		"handle request",
+		map[string]any{"from": ip, "to": urlutils.GetHost(r), "allowed": decision.Allowed},
+	)
+
+	if rl.shadow {
+		rl.observeShadow(r, key, decision.Allowed, decision.Tier)
+	} else {
+		rl.metric.Inc(decision.Allowed, key, decision.Tier)
+		setRateLimitHeaders(w.Header(), decision, rl.clock.Now())
+		if !decision.Allowed {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			rl.strike(r)
+			return
+		}
+	}
+
+	sw := proxy.NewStatusWriter(w)
+	next.ServeHTTP(sw, r)
+	if !rl.countOnStatus(sw.Status()) {
+		return
+	}
+
+	// ответ уже отправлен, поэтому отмена запроса клиентом не должна мешать учету
+	ctx := context.WithoutCancel(r.Context())
+	if _, err = lim.AllowN(ctx, key, cost); err != nil {
+		rl.log.Warn(ctx, "cannot count response", map[string]any{"key": key, "status": sw.Status(), "error": err})
+	}
+}
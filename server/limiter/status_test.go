@@ -0,0 +1,48 @@
+package limiter
+
+import (
+	"context"
+	"gateway/internal/clock"
+	"gateway/internal/logging"
+	"gateway/internal/storages"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Запрос отклоняется до проксирования, если остатка квоты не хватает на его стоимость
+func TestCountOnStatusChecksCost(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	lim := newFixedWindow(storages.NewMemoryStorage(0, 0, 0), 3, clk)
+	rl := NewRateLimiter(
+		lim,
+		logging.NewSlogAdapter(slog.New(slog.DiscardHandler)),
+		WithMetric(nopMetric{}),
+		WithClock(clk),
+		WithCost(func(*http.Request) int { return 2 }),
+		WithCountOnStatus(func(int) bool { return true }),
+	)
+
+	calls := 0
+	h := rl.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls++ }))
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+		if rec.Code != want {
+			t.Errorf("request %d: status = %d, want %d", i, rec.Code, want)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("upstream calls = %d, want 1", calls)
+	}
+
+	d, err := lim.AllowN(context.Background(), "192.0.2.1", 0)
+	if err != nil {
+		t.Fatalf("AllowN() error = %v", err)
+	}
+	if d.Remaining != 1 {
+		t.Errorf("remaining = %d, want 1", d.Remaining)
+	}
+}
@@ -0,0 +1,172 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"gateway/server/interfaces"
+	"gateway/server/urlutils"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+const subnetTier = "subnet"
+
+// IPv6 клиенту обычно выдается /64 целиком
+var DefaultSubnetMask = SubnetMask{IPv4: 24, IPv6: 64}
+
+// Длина префикса подсети для каждого семейства адресов
+type SubnetMask struct {
+	IPv4 int
+	IPv6 int
+}
+
+func (m SubnetMask) Validate() error {
+	if m.IPv4 < 1 || m.IPv4 > 32 {
+		return fmt.Errorf("ipv4 prefix length must be between 1 and 32, got %d", m.IPv4)
+	}
+	if m.IPv6 < 1 || m.IPv6 > 128 {
+		return fmt.Errorf("ipv6 prefix length must be between 1 and 128, got %d", m.IPv6)
+	}
+	return nil
+}
+
+// Подсеть адреса, например "203.0.113.0/24". false - ip не разобран
+func (m SubnetMask) Subnet(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	addr = addr.Unmap()
+
+	bits := m.IPv6
+	if addr.Is4() {
+		bits = m.IPv4
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return "", false
+	}
+	return prefix.String(), true
+}
+
+// Подсеть клиента: адреса одной подсети делят квоту.
+// Если адрес не разобран, ключом остается сам адрес
+func SubnetKey(mask SubnetMask) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, bool) {
+		ip := urlutils.GetIP(r)
+		if subnet, ok := mask.Subnet(ip); ok {
+			return subnet, true
+		}
+		return ip, true
+	})
+}
+
+// "24/64" - длины префиксов IPv4 и IPv6
+func parseSubnetMask(arg string) (SubnetMask, error) {
+	v4, v6, ok := strings.Cut(arg, "/")
+	if !ok {
+		return SubnetMask{}, fmt.Errorf("subnet mask must be <ipv4 bits>/<ipv6 bits>, got %q", arg)
+	}
+
+	var (
+		mask SubnetMask
+		err  error
+	)
+	if mask.IPv4, err = strconv.Atoi(v4); err != nil {
+		return SubnetMask{}, fmt.Errorf("invalid ipv4 prefix length %q", v4)
+	}
+	if mask.IPv6, err = strconv.Atoi(v6); err != nil {
+		return SubnetMask{}, fmt.Errorf("invalid ipv6 prefix length %q", v6)
+	}
+	return mask, mask.Validate()
+}
+
+// Второй уровень лимита: после квоты ключа проверяется квота подсети клиента.
+// Отклоненный по ключу запрос квоту подсети не расходует, а отклоненный
+// по подсети - квоту ключа
+func WithSubnetLimiter(lim interfaces.Limiter, mask SubnetMask) Option {
+	return func(rl *RateLimiter) {
+		rl.subnet = lim
+		rl.subnetMask = mask
+	}
+}
+
+// Лимитер запроса: с подсетью, если она задана
+func (rl *RateLimiter) limiter(r *http.Request) interfaces.Limiter {
+	if rl.subnet == nil {
+		return rl.lim
+	}
+	ip := urlutils.GetIP(r)
+	subnet, ok := rl.subnetMask.Subnet(ip)
+	if !ok {
+		subnet = ip
+	}
+
+	key := subnetTier + ":" + subnet
+	if rl.keyPrefix != "" {
+		key = rl.keyPrefix + ":" + key
+	}
+	return &hierarchicalLimiter{Limiter: rl.lim, subnet: rl.subnet, subnetKey: key, clock: rl.clock}
+}
+
+type hierarchicalLimiter struct {
+	interfaces.Limiter
+	subnet    interfaces.Limiter
+	subnetKey string
+	clock     interfaces.Clock
+}
+
+func (l *hierarchicalLimiter) Allow(ctx context.Context, key string) (interfaces.Decision, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// Подсеть сначала проверяется без списания: запрос, для которого в подсети
+// не осталось квоты, не расходует квоту ключа. Если подсеть исчерпали другие
+// клиенты между проверкой и списанием, списанная квота ключа не возвращается:
+// это окно короткое, а возврат есть не у всех алгоритмов
+func (l *hierarchicalLimiter) AllowN(ctx context.Context, key string, n int) (interfaces.Decision, error) {
+	if n > 0 {
+		sd, err := l.allowSubnet(ctx, 0)
+		if err != nil {
+			return interfaces.Decision{}, err
+		}
+		if sd.Limit != 0 && sd.Remaining < int64(n) {
+			sd.Allowed = false
+			if sd.RetryAfter <= 0 {
+				sd.RetryAfter = sd.Reset.Sub(l.clock.Now())
+			}
+			return sd, nil
+		}
+	}
+
+	d, err := l.Limiter.AllowN(ctx, key, n)
+	if err != nil || !d.Allowed {
+		return d, err
+	}
+
+	sd, err := l.allowSubnet(ctx, n)
+	if err != nil {
+		return interfaces.Decision{}, err
+	}
+
+	// в заголовках квоты - уровень, квота которого закончится раньше
+	if !sd.Allowed || sd.Limit != 0 && (d.Limit == 0 || sd.Remaining < d.Remaining) {
+		return sd, nil
+	}
+	return d, nil
+}
+
+func (l *hierarchicalLimiter) allowSubnet(ctx context.Context, n int) (interfaces.Decision, error) {
+	sd, err := l.subnet.AllowN(ctx, l.subnetKey, n)
+	if err != nil {
+		return interfaces.Decision{}, err
+	}
+	if sd.Tier == "" {
+		sd.Tier = subnetTier
+	} else {
+		sd.Tier = subnetTier + ":" + sd.Tier
+	}
+	return sd, nil
+}
@@ -0,0 +1,54 @@
+package limiter
+
+import (
+	"context"
+	"gateway/internal/algorithm"
+	"gateway/internal/algorithm/fixedwindow"
+	"gateway/internal/clock"
+	lim "gateway/internal/limiter"
+	"gateway/internal/storages"
+	"gateway/server/interfaces"
+	"testing"
+	"time"
+)
+
+func newFixedWindow(stor lim.Storage, limit int, clk *clock.Fake) interfaces.Limiter {
+	return lim.NewLimiter(
+		lim.NewFacade(
+			"fixed_window",
+			fixedwindow.NewFixedWindow(limit, time.Minute, clk),
+			algorithm.NewStateUnmarshaler[fixedwindow.Params](),
+		),
+		stor,
+	)
+}
+
+// Запрос, отклоненный по подсети, не расходует квоту ключа
+func TestHierarchicalLimiterSubnetFirst(t *testing.T) {
+	stor := storages.NewMemoryStorage(0, 0, 0)
+	clk := clock.NewFake(time.Unix(1_700_000_000, 0))
+	key := newFixedWindow(stor, 10, clk)
+	l := &hierarchicalLimiter{Limiter: key, subnet: newFixedWindow(stor, 2, clk), subnetKey: "subnet:10.0.0.0", clock: clk}
+
+	ctx := context.Background()
+	for i, want := range []bool{true, true, false, false, false} {
+		d, err := l.Allow(ctx, "10.0.0.1")
+		if err != nil {
+			t.Fatalf("request %d: Allow() error = %v", i, err)
+		}
+		if d.Allowed != want {
+			t.Errorf("request %d: allowed = %v, want %v", i, d.Allowed, want)
+		}
+		if !d.Allowed && (d.Tier != subnetTier || d.RetryAfter <= 0) {
+			t.Errorf("request %d: tier = %q, retryAfter = %v, want subnet tier with retry", i, d.Tier, d.RetryAfter)
+		}
+	}
+
+	d, err := key.AllowN(ctx, "10.0.0.1", 0)
+	if err != nil {
+		t.Fatalf("AllowN() error = %v", err)
+	}
+	if d.Remaining != 8 {
+		t.Errorf("key remaining = %d, want 8", d.Remaining)
+	}
+}
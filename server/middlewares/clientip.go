@@ -0,0 +1,21 @@
+package middlewares
+
+import (
+	"gateway/server/urlutils"
+	"net/http"
+)
+
+// Определяет IP клиента один раз для лимитеров, логов и Whitelist
+type ClientIP struct {
+	resolver *urlutils.ClientIPResolver
+}
+
+func NewClientIP(resolver *urlutils.ClientIPResolver) *ClientIP {
+	return &ClientIP{resolver}
+}
+
+func (mw *ClientIP) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, urlutils.WithClientIP(r, mw.resolver.Resolve(r)))
+	})
+}
@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"gateway/server/interfaces"
+	"gateway/server/urlutils"
 	"net/http"
 	"runtime/debug"
 )
@@ -22,7 +23,7 @@ func (mw *Recover) Wrap(next http.Handler) http.Handler {
 					"error":  err,
 					"method": r.Method,
 					"path":   r.URL.Path,
-					"remote": r.RemoteAddr,
+					"remote": urlutils.GetIP(r),
 					"stack":  string(debug.Stack()),
 				})
 				http.Error(w, "internal server error", http.StatusInternalServerError)
@@ -1,7 +1,7 @@
 package middlewares
 
 import (
-	"net"
+	"gateway/server/urlutils"
 	"net/http"
 	"sync"
 )
@@ -20,12 +20,7 @@ func NewWhitelist(allowedClients ...string) *Whitelist {
 
 func (mw *Whitelist) Wrap(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
-			http.Error(w, "Invalid remote address", http.StatusBadRequest)
-			return
-		}
-		if _, ok := mw.clients.Load(host); !ok {
+		if _, ok := mw.clients.Load(urlutils.GetIP(r)); !ok {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"gateway/config"
+	"gateway/server/interfaces"
+	"gateway/server/limiter"
+)
+
+// Внутренние лимитеры: у каждого блока limiter в настройках маршрутизации
+// свой лимитер, proxy.limiter действует, если блок не задан
+type InternalLimiterOptions struct {
+	// proxy.limiter, может быть nil
+	Default *config.LimiterSettings
+	// name - имя лимитера в админском API
+	Provide func(name string, cfg config.LimiterSettings) (LimiterOptions, error)
+}
+
+type limiterPolicy struct {
+	limiter *limiter.RateLimiter
+	// теневые лимитеры, выполняются до limiter
+	shadows []*limiter.RateLimiter
+	// может быть nil
+	observer interfaces.ProxyObserver
+	// лимитер возвращает квоту, если сервис недоступен
+	refunds bool
+}
+
+// Уровень, на котором может быть задана политика.
+// scope отделяет состояние политики от других политик с тем же ключом
+type policyLevel struct {
+	scope    string
+	settings *config.LimiterSettings
+}
+
+type limiterPolicies struct {
+	opts *InternalLimiterOptions
+	// один лимитер на блок настроек, даже если он действует на несколько маршрутов
+	created  map[*config.LimiterSettings]*limiterPolicy
+	register func(LimiterOptions)
+}
+
+func newLimiterPolicies(opts *InternalLimiterOptions, register func(LimiterOptions)) *limiterPolicies {
+	return &limiterPolicies{
+		opts:     opts,
+		created:  make(map[*config.LimiterSettings]*limiterPolicy),
+		register: register,
+	}
+}
+
+// Уровни перечисляются от самого специфичного, nil - лимитер не нужен
+func (p *limiterPolicies) resolve(levels ...policyLevel) (*limiterPolicy, error) {
+	if p.opts == nil {
+		return nil, nil
+	}
+
+	levels = append(levels, policyLevel{"", p.opts.Default})
+	for _, lvl := range levels {
+		if lvl.settings == nil {
+			continue
+		}
+		if policy, ok := p.created[lvl.settings]; ok {
+			return policy, nil
+		}
+
+		name := "internal"
+		if lvl.scope != "" {
+			name += ":" + lvl.scope
+		}
+
+		limOpts, err := p.opts.Provide(name, *lvl.settings)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create limiter %s: %w", lvl.scope, err)
+		}
+
+		// адаптивный лимитер создается на каждую политику и хранит состояние в памяти,
+		// а observer сообщает о ответах по адресу сервиса, поэтому префикс ему не нужен
+		options := limOpts.options(limiter.ContextValue)
+		if lvl.scope != "" && limOpts.Observer == nil {
+			options = append(options, limiter.WithKeyPrefix(lvl.scope))
+		}
+		policy := &limiterPolicy{
+			limiter:  limiter.NewRateLimiter(limOpts.Limiter, limOpts.Log, options...),
+			observer: limOpts.Observer,
+			refunds:  limOpts.RefundOnStatus != nil,
+		}
+		p.register(limOpts)
+
+		for i, settings := range lvl.settings.Shadow {
+			shadowOpts, err := p.opts.Provide(fmt.Sprintf("%s:shadow%d", name, i), settings)
+			if err != nil {
+				return nil, fmt.Errorf("cannot create shadow limiter %s: %w", lvl.scope, err)
+			}
+
+			prefix := fmt.Sprintf("shadow%d", i)
+			if lvl.scope != "" {
+				prefix = lvl.scope + ":" + prefix
+			}
+			options := append(shadowOpts.options(limiter.ContextValue), limiter.WithKeyPrefix(prefix))
+			policy.shadows = append(
+				policy.shadows, limiter.NewRateLimiter(shadowOpts.Limiter, shadowOpts.Log, options...),
+			)
+			p.register(shadowOpts)
+		}
+
+		p.created[lvl.settings] = policy
+		return policy, nil
+	}
+	return nil, nil
+}
@@ -7,6 +7,7 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type ReverseProxyAdapter struct {
@@ -15,15 +16,33 @@ type ReverseProxyAdapter struct {
 	prefix   string
 	metric   interfaces.ProxyMetric
 	inner    http.Handler
+
+	mws []interfaces.Middleware
+	// может быть nil
+	observer interfaces.ProxyObserver
 }
 
 type Option func(*ReverseProxyAdapter)
 
+// Middlewares выполняются в порядке добавления
 func WithMiddlewares(mws ...interfaces.Middleware) Option {
 	return func(p *ReverseProxyAdapter) {
-		for i := len(mws) - 1; i >= 0; i-- {
-			p.inner = mws[i].Wrap(p.inner)
-		}
+		p.mws = append(p.mws, mws...)
+	}
+}
+
+// Сообщает observer время и статус каждого ответа сервиса,
+// ответы middlewares (например, из кэша) не учитываются
+func WithObserver(observer interfaces.ProxyObserver) Option {
+	return func(p *ReverseProxyAdapter) {
+		p.observer = observer
+	}
+}
+
+// Обработчик ошибки соединения с сервисом, по умолчанию - ответ 502
+func WithErrorHandler(handler func(http.ResponseWriter, *http.Request, error)) Option {
+	return func(p *ReverseProxyAdapter) {
+		p.ReverseProxy.ErrorHandler = handler
 	}
 }
 
@@ -60,12 +79,49 @@ func NewReverseProxyAdapter(upstream, prefix string, metric interfaces.ProxyMetr
 	for _, opt := range opts {
 		opt(adapter)
 	}
+
+	if adapter.observer != nil {
+		adapter.inner = http.HandlerFunc(adapter.observe)
+	}
+	for i := len(adapter.mws) - 1; i >= 0; i-- {
+		adapter.inner = adapter.mws[i].Wrap(adapter.inner)
+	}
 	return adapter, nil
 }
 
 func (p *ReverseProxyAdapter) Upstream() string { return p.upstream }
 
+func (p *ReverseProxyAdapter) Prefix() string { return p.prefix }
+
 func (p *ReverseProxyAdapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	p.inner.ServeHTTP(w, r)
 	p.metric.Inc(fmt.Sprint(p.upstream, p.prefix))
 }
+
+func (p *ReverseProxyAdapter) observe(w http.ResponseWriter, r *http.Request) {
+	start, sw := time.Now(), NewStatusWriter(w)
+	p.ReverseProxy.ServeHTTP(sw, r)
+	p.observer.Observe(p.upstream, time.Since(start), sw.Status())
+}
+
+// Запоминает статус ответа, по умолчанию 200
+type StatusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (w *StatusWriter) Status() int { return w.status }
+
+func (w *StatusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *StatusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -24,13 +24,15 @@ func NewServer(cfg config.ServerConfig, opts ServerOptions) *Server {
 	mux := http.NewServeMux()
 	mux.Handle("/", opts.Gateway.Handler())
 
+	var handler http.Handler = mux
+
 	if opts.Handlers != nil {
 		for path, handler := range opts.Handlers {
 			mux.Handle(path, handler)
 		}
 	}
 	if opts.Middlewares != nil {
-		chain(mux, opts.Middlewares)
+		handler = chain(mux, opts.Middlewares)
 	}
 	return &Server{
 		Gateway: opts.Gateway,
@@ -38,7 +40,7 @@ func NewServer(cfg config.ServerConfig, opts ServerOptions) *Server {
 			Addr:         fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
 			ReadTimeout:  *cfg.ReadTimeout,
 			WriteTimeout: *cfg.WriteTimeout,
-			Handler:      mux,
+			Handler:      handler,
 		},
 	}
 }
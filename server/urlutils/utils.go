@@ -1,22 +1,88 @@
 package urlutils
 
 import (
+	"context"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 )
 
+type clientIPKey struct{}
+
+// IP клиента, определенный ClientIPResolver. Без него заголовкам
+// не доверяется и возвращается адрес соединения
 func GetIP(r *http.Request) string {
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip != "" {
-		return strings.Split(ip, ",")[0]
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
 	}
+	return remoteIP(r)
+}
+
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// Определяет IP клиента за доверенными прокси
+type ClientIPResolver struct {
+	trusted []netip.Prefix
+}
+
+func NewClientIPResolver(trusted []netip.Prefix) *ClientIPResolver {
+	return &ClientIPResolver{trusted: trusted}
+}
 
-	ip = r.Header.Get("X-Real-IP")
-	if ip != "" {
+// Заголовки читаются, только если соединение пришло от доверенного прокси.
+// Цепочка адресов из Forwarded (или X-Forwarded-For, если Forwarded нет)
+// проходится справа налево, доверенные адреса пропускаются. Если адрес
+// в цепочке не разобран, клиентом считается последний доверенный прокси
+func (res *ClientIPResolver) Resolve(r *http.Request) string {
+	ip := remoteIP(r)
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	// IPv4 через IPv6-сокет - тот же клиент, что и по IPv4
+	ip = addr.Unmap().String()
+	if !res.isTrusted(addr) {
+		return ip
+	}
+
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if hops == nil {
+		hops = forwardedHops(r.Header.Values("X-Forwarded-For"))
+	}
+	if hops == nil {
+		if real, err := parseNode(r.Header.Get("X-Real-IP")); err == nil {
+			return real.String()
+		}
 		return ip
 	}
 
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := parseNode(hops[i])
+		if err != nil {
+			return ip
+		}
+		ip = hop.String()
+		if !res.isTrusted(hop) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func (res *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) string {
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		return r.RemoteAddr
@@ -24,6 +90,54 @@ func GetIP(r *http.Request) string {
 	return ip
 }
 
+// Адреса из нескольких заголовков X-Forwarded-For в порядке прохождения прокси
+func forwardedHops(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// Параметры for из заголовков Forwarded (RFC 7239). Элемент без for
+// считается неизвестным узлом, чтобы не пропустить его при обходе
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			node := ""
+			for _, pair := range strings.Split(elem, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					node = value
+				}
+			}
+			hops = append(hops, node)
+		}
+	}
+	return hops
+}
+
+// Адрес узла: "1.2.3.4", "1.2.3.4:80", "[2001:db8::1]:80" или IPv6 без скобок,
+// возможно в кавычках. unknown и обфусцированные имена не разбираются
+func parseNode(node string) (netip.Addr, error) {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			node = node[1:end]
+		}
+	} else if strings.Count(node, ":") == 1 {
+		node, _, _ = strings.Cut(node, ":")
+	}
+	addr, err := netip.ParseAddr(node)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
 func GetHost(r *http.Request) string {
 	host := r.URL.Host
 	if host == "" {
@@ -0,0 +1,229 @@
+package urlutils
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestClientIPResolverResolve(t *testing.T) {
+	res := NewClientIPResolver([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ff::/48"),
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{
+			name:       "untrusted peer with spoofed headers",
+			remoteAddr: "8.8.8.8:1234",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"1.1.1.1"},
+				"Forwarded":       {"for=2.2.2.2"},
+				"X-Real-IP":       {"3.3.3.3"},
+			},
+			want: "8.8.8.8",
+		},
+		{
+			name:       "trusted peer without headers",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "x-forwarded-for",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1, 10.0.0.2"}},
+			want:       "1.1.1.1",
+		},
+		{
+			name:       "spoofed x-forwarded-for prefix is skipped",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"9.9.9.9, 1.1.1.1"}},
+			want:       "1.1.1.1",
+		},
+		{
+			name:       "multiple x-forwarded-for headers",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"9.9.9.9", "1.1.1.1, 10.0.0.2"}},
+			want:       "1.1.1.1",
+		},
+		{
+			name:       "forwarded takes precedence over x-forwarded-for",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"Forwarded":       {"for=1.1.1.1;proto=https"},
+				"X-Forwarded-For": {"2.2.2.2"},
+			},
+			want: "1.1.1.1",
+		},
+		{
+			name:       "forwarded quoted ipv6 with port",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {`for="[2001:db8::1]:80"`}},
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "forwarded unknown node",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=1.1.1.1, for=unknown"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "forwarded obfuscated node",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=1.1.1.1, for=_hidden"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "forwarded element without for",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=1.1.1.1, by=10.0.0.2"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "unparsed node behind trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=_hidden, for=10.0.0.2"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "all trusted chain",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "x-real-ip from trusted peer",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Real-IP": {"1.1.1.1"}},
+			want:       "1.1.1.1",
+		},
+		{
+			name:       "invalid x-real-ip",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Real-IP": {"unknown"}},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "ipv4-mapped trusted peer",
+			remoteAddr: "[::ffff:10.0.0.1]:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1"}},
+			want:       "1.1.1.1",
+		},
+		{
+			name:       "ipv4-mapped trusted peer without headers",
+			remoteAddr: "[::ffff:10.0.0.1]:1234",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "ipv4-mapped hop",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"::ffff:1.1.1.1, ::ffff:10.0.0.2"}},
+			want:       "1.1.1.1",
+		},
+		{
+			name:       "ipv4-mapped untrusted peer",
+			remoteAddr: "[::ffff:8.8.8.8]:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1"}},
+			want:       "8.8.8.8",
+		},
+		{
+			name:       "trusted ipv6 peer",
+			remoteAddr: "[2001:db8:ff::1]:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"2001:db8::1"}},
+			want:       "2001:db8::1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				for _, v := range values {
+					r.Header.Add(name, v)
+				}
+			}
+
+			if got := res.Resolve(r); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{name: "no header"},
+		{name: "single", values: []string{"for=1.1.1.1"}, want: []string{"1.1.1.1"}},
+		{
+			name:   "several elements and headers",
+			values: []string{"for=1.1.1.1, for=2.2.2.2", "For=3.3.3.3;proto=https"},
+			want:   []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+		},
+		{
+			name:   "quoted ipv6",
+			values: []string{`for="[2001:db8::1]:80";by=10.0.0.1`},
+			want:   []string{`"[2001:db8::1]:80"`},
+		},
+		{
+			name:   "element without for",
+			values: []string{"for=1.1.1.1, proto=http;by=10.0.0.1"},
+			want:   []string{"1.1.1.1", ""},
+		},
+		{name: "unknown", values: []string{"for=unknown"}, want: []string{"unknown"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forwardedFor(tt.values); !slices.Equal(got, tt.want) {
+				t.Errorf("forwardedFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNode(t *testing.T) {
+	tests := []struct {
+		node    string
+		want    string
+		wantErr bool
+	}{
+		{node: "1.2.3.4", want: "1.2.3.4"},
+		{node: "1.2.3.4:80", want: "1.2.3.4"},
+		{node: ` "1.2.3.4" `, want: "1.2.3.4"},
+		{node: "2001:db8::1", want: "2001:db8::1"},
+		{node: "[2001:db8::1]", want: "2001:db8::1"},
+		{node: `"[2001:db8::1]:80"`, want: "2001:db8::1"},
+		{node: "::ffff:1.2.3.4", want: "1.2.3.4"},
+		{node: "[::ffff:1.2.3.4]:80", want: "1.2.3.4"},
+		{node: "unknown", wantErr: true},
+		{node: "_hidden", wantErr: true},
+		{node: `"_hidden:80"`, wantErr: true},
+		{node: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.node, func(t *testing.T) {
+			got, err := parseNode(tt.node)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("parseNode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}